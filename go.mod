@@ -1,3 +1,5 @@
 module goblogs
 
 go 1.23.0
+
+require golang.org/x/sync v0.16.0
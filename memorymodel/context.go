@@ -0,0 +1,498 @@
+package memorymodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Examples in this file use the context package. Cancelling a context closes its Done
+// channel, and like any channel close that is synchronized before every receive that
+// observes it
+
+// Example12 hands work to a worker and then stops it with cancel. After the worker observes
+// ctx.Done(), ctx.Err() reports context.Canceled
+func Example12() {
+	processed, err := cancelWorker(3)
+	fmt.Fprintln(Output, "processed", processed, "items")
+	fmt.Fprintln(Output, err)
+}
+
+// workerResult is what a worker reports when it stops
+type workerResult struct {
+	processed int
+	err       error
+}
+
+// cancelWorker sends n items to a worker, cancels it and returns what the worker reported
+func cancelWorker(n int) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	work := make(chan int) // unbuffered, so each send completes only once the worker took it
+	done := make(chan workerResult)
+
+	go func() {
+		var processed int
+		for {
+			select {
+			case <-ctx.Done():
+				done <- workerResult{processed, ctx.Err()}
+				return
+			case <-work:
+				processed++
+			}
+		}
+	}()
+
+	for i := range n {
+		work <- i
+	}
+	cancel()
+	res := <-done
+	return res.processed, res.err
+}
+
+// errTaskFailed is returned by the failing task in Example21
+var errTaskFailed = errors.New("task failed")
+
+// Example21 runs several tasks in an errgroup where one of them fails. Wait returns the first
+// error, and the group's context is cancelled so the remaining tasks can stop early
+func Example21() {
+	cancelled, err := firstError(4, 2)
+	fmt.Fprintln(Output, "wait:", err)
+	fmt.Fprintln(Output, "cancelled tasks:", cancelled)
+}
+
+// firstError runs n tasks in an errgroup where the task at index failing returns
+// errTaskFailed and the others wait for cancellation. It returns the number of tasks that
+// observed the cancellation and the error from Wait
+func firstError(n, failing int) (int64, error) {
+	g, ctx := errgroup.WithContext(context.Background())
+	var cancelled atomic.Int64
+
+	for i := range n {
+		g.Go(func() error {
+			if i == failing {
+				return errTaskFailed
+			}
+			select {
+			case <-ctx.Done(): // cancelled as soon as any task returns an error
+				cancelled.Add(1)
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		})
+	}
+	// Wait returns only after every task returned, so all writes to cancelled are visible
+	err := g.Wait()
+	return cancelled.Load(), err
+}
+
+// Example25 gives a slow operation a short deadline with context.WithTimeout. The deadline
+// passes first, so the operation is abandoned and reports context.DeadlineExceeded
+func Example25() {
+	fmt.Fprintln(Output, withDeadline(10*time.Millisecond, time.Second))
+}
+
+// withDeadline runs an operation that takes work to complete with the given timeout
+func withDeadline(timeout, work time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel() // releases the context's timer even if the operation finishes first
+	return slowOperation(ctx, work)
+}
+
+// slowOperation pretends to work for d unless ctx is done first
+func slowOperation(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Example31 shuts down a pool of workers while they still have work in flight. Cancelling the
+// context stops the workers from taking new items, and waiting on the WaitGroup ensures every
+// item a worker already took is finished before shutdown returns
+func Example31() {
+	accepted, processed := gracefulShutdown(3, 10, time.Millisecond)
+	fmt.Fprintln(Output, "accepted:", accepted)
+	fmt.Fprintln(Output, "processed:", processed)
+}
+
+// gracefulShutdown submits items to workers that each take work to process them, shuts the
+// workers down and returns how many items were accepted and how many were fully processed
+func gracefulShutdown(workers, items int, work time.Duration) (accepted, processed int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := make(chan int) // unbuffered: an item is accepted once a worker has received it
+	var nAccepted, nProcessed atomic.Int64
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-queue:
+					nAccepted.Add(1)
+					time.Sleep(work) // in-flight work is not interrupted by cancellation
+					nProcessed.Add(1)
+				}
+			}
+		}()
+	}
+
+	for i := range items {
+		queue <- i
+	}
+	cancel()  // stop taking new items
+	wg.Wait() // let in-flight items finish
+	return nAccepted.Load(), nProcessed.Load()
+}
+
+// requestIDKey is the context key for a request ID. An unexported key type prevents
+// collisions with keys defined in other packages
+type requestIDKey struct{}
+
+// Example41 threads a request-scoped value through a context to a child goroutine.
+// A context is immutable: WithValue returns a new context wrapping the parent instead of
+// modifying it. The new context is created before the go statement, which is synchronized
+// before the child starts, so the child safely observes the value without further locking.
+// Context values are for request-scoped data that crosses API boundaries, like request IDs or
+// credentials. They are not a way to pass optional parameters: those belong in the function
+// signature, where the compiler can check them
+func Example41() {
+	fmt.Fprintln(Output, requestIDInChild("req-42"))
+}
+
+// requestIDInChild stores id in a context and returns the value a child goroutine reads
+func requestIDInChild(id string) string {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, id)
+	seen := make(chan string)
+	go func(ctx context.Context) {
+		v, _ := ctx.Value(requestIDKey{}).(string)
+		seen <- v
+	}(ctx)
+	return <-seen
+}
+
+// Example48 gates downloads with a semaphore, like Example6, and gives every task the same
+// context. Cancelling it aborts tasks in the middle of their work as well as tasks still
+// waiting for a slot, and every slot is released on the way out
+func Example48() {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan int)
+	go func() {
+		<-started
+		<-started // both slots taken
+		cancel()
+		for range started { // drain any later starts, there should be none
+		}
+	}()
+	errs, held := gatedDownloads(ctx, 5, 2, time.Second, started)
+	close(started)
+
+	canceled := 0
+	for _, err := range errs {
+		if err == context.Canceled {
+			canceled++
+		}
+	}
+	fmt.Fprintln(Output, "canceled:", canceled, "slots held:", held)
+}
+
+// gatedDownloads runs tasks downloads, each taking work, with at most limit running at once.
+// Each task sends its index on started once it holds a slot. It returns each task's error and
+// the number of semaphore slots still held afterwards
+func gatedDownloads(ctx context.Context, tasks, limit int, work time.Duration, started chan<- int) (errs []error, held int) {
+	sem := make(chan struct{}, limit)
+	errs = make([]error, tasks)
+	var wg sync.WaitGroup
+	wg.Add(tasks)
+	for i := range tasks {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = download(ctx, sem, work, func() { started <- i })
+		}(i)
+	}
+	wg.Wait()
+	return errs, len(sem)
+}
+
+// download waits for a slot in sem, calls onStart and then works for d, giving up as soon as
+// ctx is done
+func download(ctx context.Context, sem chan struct{}, d time.Duration, onStart func()) error {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err() // cancelled while waiting for a slot
+	}
+	defer func() { <-sem }()
+
+	if ctx.Err() != nil {
+		// select picks randomly when both cases are ready, so check again before starting
+		return ctx.Err()
+	}
+	onStart()
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err() // cancelled in the middle of the work
+	}
+}
+
+// Example55 runs a worker whose result may arrive after the caller has given up. The worker
+// selects between sending its result and ctx.Done(), so whichever side wins the race the
+// worker never blocks forever on a send nobody receives, as in Example13Leak
+func Example55() {
+	v, err := resultOrCancel(time.Millisecond, time.Second)
+	fmt.Fprintln(Output, "result wins:", v, err)
+	v, err = resultOrCancel(time.Second, time.Millisecond)
+	fmt.Fprintln(Output, "cancel wins:", v, err)
+}
+
+// resultOrCancel waits up to patience for a worker that needs work to compute its result
+func resultOrCancel(work, patience time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), patience)
+	defer cancel()
+
+	results := make(chan int) // unbuffered: a send needs a receiver
+	go func() {
+		select {
+		case <-time.After(work): // computing the result
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case results <- 42:
+		case <-ctx.Done(): // the caller is gone, drop the result
+		}
+	}()
+
+	select {
+	case v := <-results:
+		return v, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Example64 puts Example6, Example20 and Example21 together the way production code usually
+// needs them: a semaphore caps how many tasks run at once, the errgroup's context is cancelled
+// by the first failure so the remaining tasks stop early and no new ones start, and Wait
+// returns that first error along with whatever results were collected before it
+func Example64() {
+	fast := func(v int) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) { return v, nil }
+	}
+	slow := func(ctx context.Context) (int, error) {
+		select {
+		case <-time.After(time.Second):
+			return -1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	failing := func(context.Context) (int, error) { return 0, errTaskFailed }
+
+	results, err := boundedGroup(context.Background(), 2, []func(context.Context) (int, error){
+		fast(1), fast(2), fast(3), failing, slow, slow,
+	})
+	slices.Sort(results)
+	fmt.Fprintln(Output, "results:", results)
+	fmt.Fprintln(Output, "wait:", err)
+}
+
+// boundedGroup runs tasks with at most limit of them in flight. It stops starting tasks once
+// one has failed or ctx is done, and returns the results of the tasks that succeeded, in
+// completion order, and the first error
+func boundedGroup(ctx context.Context, limit int64, tasks []func(context.Context) (int, error)) ([]int, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	sem := semaphore.NewWeighted(limit)
+	var mu sync.Mutex
+	var results []int
+
+	for _, task := range tasks {
+		// acquiring before g.Go keeps the number of goroutines bounded as well as the work
+		if err := sem.Acquire(ctx, 1); err != nil {
+			break // cancelled; Wait reports the error that caused it
+		}
+		g.Go(func() error {
+			defer sem.Release(1)
+			v, err := task(ctx)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			results = append(results, v)
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := g.Wait()
+	// every task has returned, so results is no longer written to
+	return results, err
+}
+
+// First runs every function in fns concurrently and returns the first successful result. As
+// soon as one succeeds the context passed to the others is cancelled so they can stop early.
+// If all of them fail, First returns the error of the last one to fail. With no functions it
+// returns the zero value and a nil error
+func First[T any](ctx context.Context, fns ...func(context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the losers once the winner is returned
+
+	type result struct {
+		v   T
+		err error
+	}
+	// buffered so the losers can deliver their results and exit after First has returned
+	results := make(chan result, len(fns))
+	for _, fn := range fns {
+		go func() {
+			v, err := fn(ctx)
+			results <- result{v, err}
+		}()
+	}
+
+	var zero T
+	var err error
+	for range fns {
+		r := <-results
+		if r.err == nil {
+			return r.v, nil
+		}
+		err = r.err
+	}
+	return zero, err
+}
+
+// Example73 asks three mirrors for the same file. The nearest one fails, the second nearest
+// answers and the far one is cancelled
+func Example73() {
+	mirror := func(name string, latency time.Duration, err error) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			select {
+			case <-time.After(latency):
+				if err != nil {
+					return "", err
+				}
+				return name, nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+	}
+	v, err := First(context.Background(),
+		mirror("near", time.Millisecond, errTaskFailed),
+		mirror("middle", 10*time.Millisecond, nil),
+		mirror("far", time.Second, nil),
+	)
+	fmt.Fprintln(Output, v, err)
+}
+
+// Example82 sets one deadline at the top of a call tree. Each function passes its context on
+// to the next, and the blocking operation at the bottom selects on ctx.Done(), so the single
+// outer deadline bounds the whole tree without any function in between knowing about it. The
+// error travels back up, wrapped by each level
+func Example82() {
+	_, err := handleRequest(10*time.Millisecond, time.Second)
+	fmt.Fprintln(Output, err)
+	fmt.Fprintln(Output, "deadline exceeded:", errors.Is(err, context.DeadlineExceeded))
+}
+
+// handleRequest gives a request timeout to complete a lookup whose query takes work
+func handleRequest(timeout, work time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	user, err := loadUser(ctx, work)
+	if err != nil {
+		return "", fmt.Errorf("handle request: %w", err)
+	}
+	return user, nil
+}
+
+// loadUser looks a user up, knowing nothing about the deadline it runs under
+func loadUser(ctx context.Context, work time.Duration) (string, error) {
+	row, err := query(ctx, work)
+	if err != nil {
+		return "", fmt.Errorf("load user: %w", err)
+	}
+	return "user " + row, nil
+}
+
+// query is the blocking operation at the bottom of the tree. It takes work to complete
+// unless ctx is done first
+func query(ctx context.Context, work time.Duration) (string, error) {
+	select {
+	case <-time.After(work):
+		return "hello world", nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("query: %w", ctx.Err())
+	}
+}
+
+// maxRetryDelay caps the wait between two attempts of Retry
+const maxRetryDelay = 30 * time.Second
+
+// Retry calls f until it succeeds, at most attempts times, and returns nil as soon as it
+// does. Attempts below 1 behave like 1. Between attempts it waits base, then 2*base, 4*base
+// and so on, never longer than maxRetryDelay. If ctx is done before an attempt or during a
+// wait, Retry gives up early and returns ctx.Err(), so with a context that is already done f
+// is never called. Otherwise it returns the error of the last attempt
+func Retry(ctx context.Context, attempts int, base time.Duration, f func() error) error {
+	attempts = max(attempts, 1)
+	var err error
+	delay := min(base, maxRetryDelay)
+	for i := range attempts {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err = f(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break // no point waiting after the last attempt
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		delay = nextRetryDelay(delay)
+	}
+	return err
+}
+
+// nextRetryDelay doubles delay up to maxRetryDelay. Since the result never exceeds the cap,
+// repeated doubling cannot overflow time.Duration
+func nextRetryDelay(delay time.Duration) time.Duration {
+	return min(delay*2, maxRetryDelay)
+}
+
+// Example91 retries a flaky operation that fails twice before it succeeds
+func Example91() {
+	calls := 0
+	err := Retry(context.Background(), 5, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return errTaskFailed
+		}
+		return nil
+	})
+	fmt.Fprintln(Output, "calls:", calls, "error:", err)
+}
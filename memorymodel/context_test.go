@@ -0,0 +1,387 @@
+package memorymodel
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExample12WorkerExits(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	processed, err := cancelWorker(5)
+	if processed != 5 {
+		t.Errorf("worker processed %d items, want 5", processed)
+	}
+	if err != context.Canceled {
+		t.Errorf("worker reported %v, want %v", err, context.Canceled)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample21FirstError(t *testing.T) {
+	start := time.Now()
+	cancelled, err := firstError(5, 3)
+	if err != errTaskFailed {
+		t.Errorf("Wait returned %v, want %v", err, errTaskFailed)
+	}
+	if cancelled != 4 {
+		t.Errorf("%d tasks observed cancellation, want 4", cancelled)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("firstError took %v, want siblings to stop early", elapsed)
+	}
+}
+
+func TestExample25Timeout(t *testing.T) {
+	start := time.Now()
+	err := withDeadline(20*time.Millisecond, 5*time.Second)
+	if err != context.DeadlineExceeded {
+		t.Errorf("withDeadline returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("withDeadline took %v, want it to return shortly after the timeout", elapsed)
+	}
+}
+
+func TestExample25CompletesInTime(t *testing.T) {
+	if err := withDeadline(time.Second, time.Millisecond); err != nil {
+		t.Errorf("withDeadline returned %v, want nil", err)
+	}
+}
+
+func TestExample31GracefulShutdown(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	accepted, processed := gracefulShutdown(4, 40, 2*time.Millisecond)
+	if accepted != 40 {
+		t.Errorf("accepted %d items, want 40", accepted)
+	}
+	if processed != accepted {
+		t.Errorf("processed %d of %d accepted items", processed, accepted)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample41ValuePropagation(t *testing.T) {
+	for _, id := range []string{"req-1", "req-2", ""} {
+		if got := requestIDInChild(id); got != id {
+			t.Errorf("child read %q, want %q", got, id)
+		}
+	}
+}
+
+func TestExample48CancelGatedDownloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan int)
+	var startedTasks []int
+	collected := make(chan struct{})
+	go func() {
+		for i := range started {
+			startedTasks = append(startedTasks, i)
+			if len(startedTasks) == 3 {
+				cancel() // partway: all slots are busy, the rest are still waiting
+			}
+		}
+		close(collected)
+	}()
+
+	errs, held := gatedDownloads(ctx, 10, 3, 5*time.Second, started)
+	close(started)
+	<-collected
+
+	if len(startedTasks) != 3 {
+		t.Errorf("%d tasks started, want 3", len(startedTasks))
+	}
+	for i, err := range errs {
+		if err != context.Canceled {
+			t.Errorf("task %d returned %v, want %v", i, err, context.Canceled)
+		}
+	}
+	if held != 0 {
+		t.Errorf("%d semaphore slots still held, want 0", held)
+	}
+}
+
+func TestExample48Completes(t *testing.T) {
+	started := make(chan int, 4)
+	errs, held := gatedDownloads(context.Background(), 4, 2, time.Millisecond, started)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("task %d returned %v, want nil", i, err)
+		}
+	}
+	if held != 0 || len(started) != 4 {
+		t.Errorf("held = %d, started = %d; want 0 and 4", held, len(started))
+	}
+}
+
+func TestExample55ResultWins(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	v, err := resultOrCancel(time.Millisecond, time.Second)
+	if v != 42 || err != nil {
+		t.Errorf("resultOrCancel = %d, %v; want 42, nil", v, err)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample55CancelWins(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	v, err := resultOrCancel(50*time.Millisecond, time.Millisecond)
+	if v != 0 || err != context.DeadlineExceeded {
+		t.Errorf("resultOrCancel = %d, %v; want 0, %v", v, err, context.DeadlineExceeded)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample64FirstErrorCancels(t *testing.T) {
+	var inFlight, peak, cancelled atomic.Int64
+	track := func(task func(context.Context) (int, error)) func(context.Context) (int, error) {
+		return func(ctx context.Context) (int, error) {
+			raiseMax(&peak, inFlight.Add(1))
+			defer inFlight.Add(-1)
+			return task(ctx)
+		}
+	}
+	fast := func(v int) func(context.Context) (int, error) {
+		return track(func(context.Context) (int, error) { return v, nil })
+	}
+	slow := track(func(ctx context.Context) (int, error) {
+		select {
+		case <-time.After(5 * time.Second):
+			return -1, nil
+		case <-ctx.Done():
+			cancelled.Add(1)
+			return 0, ctx.Err()
+		}
+	})
+	failing := track(func(context.Context) (int, error) {
+		time.Sleep(10 * time.Millisecond) // let the fast tasks finish first
+		return 0, errTaskFailed
+	})
+
+	start := time.Now()
+	results, err := boundedGroup(context.Background(), 3, []func(context.Context) (int, error){
+		slow, slow, fast(1), fast(2), failing, slow, slow,
+	})
+	if err != errTaskFailed {
+		t.Errorf("boundedGroup returned %v, want %v", err, errTaskFailed)
+	}
+	slices.Sort(results)
+	if want := []int{1, 2}; !slices.Equal(results, want) {
+		t.Errorf("collected results %v, want %v", results, want)
+	}
+	if n := cancelled.Load(); n < 2 {
+		t.Errorf("%d slow tasks observed cancellation, want at least the 2 in flight", n)
+	}
+	if n := peak.Load(); n > 3 {
+		t.Errorf("%d tasks ran at once, want at most 3", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("boundedGroup took %v, want slow tasks to stop early", elapsed)
+	}
+}
+
+func TestExample64AllSucceed(t *testing.T) {
+	var tasks []func(context.Context) (int, error)
+	for i := range 20 {
+		tasks = append(tasks, func(context.Context) (int, error) { return i, nil })
+	}
+	results, err := boundedGroup(context.Background(), 4, tasks)
+	if err != nil {
+		t.Errorf("boundedGroup returned %v, want nil", err)
+	}
+	slices.Sort(results)
+	want := make([]int, 20)
+	for i := range want {
+		want[i] = i
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("collected results %v, want %v", results, want)
+	}
+}
+
+func TestExample73FastestSuccessWins(t *testing.T) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	cancelled := make(map[string]bool)
+	staggered := func(name string, delay time.Duration, err error) func(context.Context) (string, error) {
+		wg.Add(1)
+		return func(ctx context.Context) (string, error) {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+				return name, err
+			case <-ctx.Done():
+				mu.Lock()
+				cancelled[name] = true
+				mu.Unlock()
+				return "", ctx.Err()
+			}
+		}
+	}
+
+	start := time.Now()
+	v, err := First(context.Background(),
+		staggered("slowest", 5*time.Second, nil),
+		staggered("failing", time.Millisecond, errTaskFailed),
+		staggered("fastest", 20*time.Millisecond, nil),
+		staggered("slow", 2*time.Second, nil),
+	)
+	if v != "fastest" || err != nil {
+		t.Errorf("First = %q, %v, want %q, nil", v, err, "fastest")
+	}
+	wg.Wait() // the losers return only once they observe the cancellation
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("losers took %v to stop", elapsed)
+	}
+	if want := map[string]bool{"slow": true, "slowest": true}; !maps.Equal(cancelled, want) {
+		t.Errorf("cancelled %v, want %v", cancelled, want)
+	}
+}
+
+func TestExample73AllFail(t *testing.T) {
+	errLast := errors.New("last")
+	fail := func(delay time.Duration, err error) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) {
+			time.Sleep(delay)
+			return 0, err
+		}
+	}
+	v, err := First(context.Background(), fail(0, errTaskFailed), fail(20*time.Millisecond, errLast))
+	if v != 0 || err != errLast {
+		t.Errorf("First = %d, %v, want 0, %v", v, err, errLast)
+	}
+}
+
+func TestExample73ParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := First(ctx, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("First returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExample82DeadlinePropagates(t *testing.T) {
+	start := time.Now()
+	user, err := handleRequest(10*time.Millisecond, 5*time.Second)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("handleRequest returned %v, want it to wrap %v", err, context.DeadlineExceeded)
+	}
+	if want := "handle request: load user: query: context deadline exceeded"; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+	if user != "" {
+		t.Errorf("user = %q after a timeout, want none", user)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handleRequest took %v, want the query cancelled at the deadline", elapsed)
+	}
+}
+
+func TestExample82WithinDeadline(t *testing.T) {
+	user, err := handleRequest(time.Second, time.Millisecond)
+	if user != "user hello world" || err != nil {
+		t.Errorf("handleRequest = %q, %v, want %q, nil", user, err, "user hello world")
+	}
+}
+
+func TestExample91RetrySucceeds(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := Retry(context.Background(), 5, 5*time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return errTaskFailed
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Retry = %v after %d calls, want nil after 3", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Retry took %v, want backoffs of 5ms and 10ms", elapsed)
+	}
+}
+
+func TestExample91RetryExhausted(t *testing.T) {
+	calls := 0
+	errs := []error{errors.New("first"), errors.New("second"), errors.New("last")}
+	err := Retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		return errs[calls-1]
+	})
+	if err != errs[2] || calls != 3 {
+		t.Errorf("Retry = %v after %d calls, want %v after 3", err, calls, errs[2])
+	}
+}
+
+func TestExample91RetryCallsAtLeastOnce(t *testing.T) {
+	for _, attempts := range []int{0, -1} {
+		calls := 0
+		err := Retry(context.Background(), attempts, time.Millisecond, func() error {
+			calls++
+			return errTaskFailed
+		})
+		if err != errTaskFailed || calls != 1 {
+			t.Errorf("Retry with %d attempts = %v after %d calls, want %v after 1", attempts, err, calls, errTaskFailed)
+		}
+	}
+}
+
+func TestExample91RetryCancelledBeforeFirstAttempt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := Retry(ctx, 3, time.Millisecond, func() error {
+		calls++
+		return nil
+	})
+	if err != context.Canceled || calls != 0 {
+		t.Errorf("Retry = %v after %d calls, want %v after 0", err, calls, context.Canceled)
+	}
+}
+
+func TestExample91RetryDelayCapped(t *testing.T) {
+	delay := time.Millisecond
+	for range 100 {
+		delay = nextRetryDelay(delay)
+		if delay <= 0 || delay > maxRetryDelay {
+			t.Fatalf("delay = %v, want within (0, %v]", delay, maxRetryDelay)
+		}
+	}
+	if delay != maxRetryDelay {
+		t.Errorf("delay settled at %v, want %v", delay, maxRetryDelay)
+	}
+}
+
+func TestExample91RetryCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	err := Retry(ctx, 5, time.Minute, func() error {
+		calls++
+		return errTaskFailed
+	})
+	if err != context.Canceled {
+		t.Errorf("Retry returned %v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("f was called %d times, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Retry took %v to notice the cancellation", elapsed)
+	}
+}
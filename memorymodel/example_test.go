@@ -0,0 +1,609 @@
+package memorymodel_test
+
+import "goblogs/memorymodel"
+
+// Examples whose output depends on scheduling, such as Example1, Example2 and the racy
+// teaching examples, are intentionally not verified here.
+
+func ExampleExample1Sync() {
+	memorymodel.Example1Sync()
+	// Output: hello world
+}
+
+func ExampleExample3() {
+	memorymodel.Example3()
+	// Output: hello world
+}
+
+func ExampleExample4() {
+	memorymodel.Example4()
+	// Output:
+	// 0
+	// 0
+	// hello world
+}
+
+func ExampleExample5() {
+	memorymodel.Example5()
+	// Output: hello world
+}
+
+func ExampleExample6() {
+	memorymodel.Example6()
+	// Unordered output:
+	// work function:  0
+	// work function:  1
+	// work function:  2
+	// work function:  3
+	// work function:  4
+	// work function:  5
+	// work function:  6
+	// work function:  7
+	// work function:  8
+	// work function:  9
+}
+
+func ExampleExample7() {
+	memorymodel.Example7()
+	// Output: hello world
+}
+
+func ExampleExample8() {
+	memorymodel.Example8()
+	// Output:
+	// hello world
+	// hello world
+	// hello world
+}
+
+func ExampleExample9() {
+	memorymodel.Example9()
+	// Output: hello world
+}
+
+func ExampleExample10Fixed() {
+	memorymodel.Example10Fixed()
+	// Output: 1000
+}
+
+func ExampleExample11() {
+	memorymodel.Example11()
+	// Output:
+	// 1
+	// 2
+	// 3
+	// 4
+	// 5
+}
+
+func ExampleExample12() {
+	memorymodel.Example12()
+	// Output:
+	// processed 3 items
+	// context canceled
+}
+
+func ExampleExample13Fixed() {
+	memorymodel.Example13Fixed()
+	// Output: query: context canceled
+}
+
+func ExampleExample14() {
+	memorymodel.Example14()
+	// Output: 55
+}
+
+func ExampleExample15Correct() {
+	memorymodel.Example15Correct()
+	// Output: instances: 1
+}
+
+func ExampleExample17() {
+	memorymodel.Example17()
+	// Output:
+	// var initFirst
+	// var initSecond
+	// init
+}
+
+func ExampleExample18() {
+	memorymodel.Example18()
+	// Output:
+	// torn reads: 0
+	// final config: v3
+}
+
+func ExampleExample19() {
+	memorymodel.Example19()
+	// Output:
+	// hello world
+	// hello world
+	// hello world
+}
+
+func ExampleExample20() {
+	memorymodel.Example20()
+	// Output:
+	// completed 8 tasks, error: <nil>
+	// budget respected: true
+}
+
+func ExampleExample21() {
+	memorymodel.Example21()
+	// Output:
+	// wait: task failed
+	// cancelled tasks: 3
+}
+
+func ExampleExample22() {
+	memorymodel.Example22()
+	// Output:
+	// hello world
+	// hello world
+	// hello world
+}
+
+func ExampleExample23() {
+	memorymodel.Example23()
+	// Output: 4000
+}
+
+func ExampleExample24() {
+	memorymodel.Example24()
+	// Output:
+	// A
+	// B
+	// A
+	// B
+	// A
+	// B
+}
+
+func ExampleExample25() {
+	memorymodel.Example25()
+	// Output: context deadline exceeded
+}
+
+func ExampleExample26() {
+	memorymodel.Example26()
+	// Output: entries: 20
+}
+
+func ExampleExample27() {
+	memorymodel.Example27()
+	// Output:
+	// hello 0
+	// hello 1
+	// hello 2
+}
+
+func ExampleExample28() {
+	memorymodel.Example28()
+	// Output: 30
+}
+
+func ExampleExample29() {
+	memorymodel.Example29()
+	// Output:
+	// 1
+	// 2
+	// 3
+	// 4
+	// 5
+	// 6
+	// 7
+	// 9
+}
+
+func ExampleExample30() {
+	memorymodel.Example30()
+	// Output:
+	// lock busy, doing something else
+	// lock acquired
+}
+
+func ExampleExample31() {
+	memorymodel.Example31()
+	// Output:
+	// accepted: 10
+	// processed: 10
+}
+
+func ExampleExample32() {
+	memorymodel.Example32()
+	// Output:
+	// request 1
+	// request 2
+	// request 3
+}
+
+func ExampleExample33Fixed() {
+	memorymodel.Example33Fixed()
+	// Output: runs with both goroutines inside: 0
+}
+
+func ExampleExample34() {
+	memorymodel.Example34()
+	// Output: finalized: hello world true
+}
+
+func ExampleExample35() {
+	memorymodel.Example35()
+	// Output:
+	// hello world
+	// hello world
+	// hello world
+	// setup calls: 1
+}
+
+func ExampleExample36() {
+	memorymodel.Example36()
+	// Output: 15
+}
+
+func ExampleExample37() {
+	memorymodel.Example37()
+	// Output: 10 10
+}
+
+func ExampleExample38() {
+	memorymodel.Example38()
+	// Output: 6
+}
+
+func ExampleExample39() {
+	memorymodel.Example39()
+	// Output:
+	// 42 hello world
+	// 42 hello world
+}
+
+func ExampleExample40() {
+	memorymodel.Example40()
+	// Output: phase 1 finished before phase 2 started: true
+}
+
+func ExampleExample41() {
+	memorymodel.Example41()
+	// Output: req-42
+}
+
+func ExampleExample42() {
+	memorymodel.Example42()
+	// Output: 36
+}
+
+func ExampleExample43() {
+	memorymodel.Example43()
+	// Output: recovered: close of closed channel
+}
+
+func ExampleExample43Safe() {
+	memorymodel.Example43Safe()
+	// Output: closes: 1
+}
+
+func ExampleExample45() {
+	memorymodel.Example45()
+	// Output:
+	// "fast" <nil>
+	// "" timed out
+}
+
+func ExampleExample46Correct() {
+	memorymodel.Example46Correct()
+	// Output: finished before Wait returned: 50
+}
+
+func ExampleExample47() {
+	memorymodel.Example47()
+	// Output:
+	// sent: 1 dropped: 2
+	// received 0
+	// nothing ready
+}
+
+func ExampleExample48() {
+	memorymodel.Example48()
+	// Output: canceled: 5 slots held: 0
+}
+
+func ExampleExample49() {
+	memorymodel.Example49()
+	// Output:
+	// loop ran: true
+	// loop stopped: shutdown requested
+}
+
+func ExampleExample50() {
+	memorymodel.Example50()
+	// Output:
+	// 4
+	// 16
+	// 36
+}
+
+func ExampleExample51() {
+	memorymodel.Example51()
+	// Output:
+	// TryLock while read-locked: false
+	// TryRLock while read-locked: true
+	// TryRLock while write-locked: false
+	// TryLock when free: true
+}
+
+func ExampleExample52() {
+	memorymodel.Example52()
+	// Output:
+	// 3
+	// 1
+	// 4
+}
+
+func ExampleExample53() {
+	memorymodel.Example53()
+	// Output:
+	// 1
+	// 2
+	// 3
+	// range loop done
+}
+
+func ExampleExample54() {
+	memorymodel.Example54()
+	// Output: reported: 3
+}
+
+func ExampleExample55() {
+	memorymodel.Example55()
+	// Output:
+	// result wins: 42 <nil>
+	// cancel wins: 0 context deadline exceeded
+}
+
+func ExampleExample56() {
+	memorymodel.Example56()
+	// Output:
+	// spinlock: 4000
+	// mutex: 4000
+}
+
+func ExampleExample57() {
+	memorymodel.Example57()
+	// Output:
+	// sync.Once instances: 1
+	// atomic.Pointer instances: 1
+	// initializations: 2
+}
+
+func ExampleExample58() {
+	memorymodel.Example58()
+	// Output: parameter: 10 distinct values
+}
+
+func ExampleExample59Leaky() {
+	memorymodel.Example59Leaky()
+	// Output: processed 3 events
+}
+
+func ExampleExample59Fixed() {
+	memorymodel.Example59Fixed()
+	// Output: processed 3 events
+}
+
+func ExampleExample60() {
+	memorymodel.Example60()
+	// Output:
+	// 3 1 2
+	// 3 1 2
+}
+
+func ExampleExample61() {
+	memorymodel.Example61()
+	// Output:
+	// receive 0 before send 2 completed: true
+	// receive 1 before send 3 completed: true
+	// receive 2 before send 4 completed: true
+}
+
+func ExampleExample62() {
+	memorymodel.Example62()
+	// Output:
+	// child 0: <nil>
+	// child 1: panic: hello world
+	// child 2: <nil>
+}
+
+func ExampleExample63() {
+	memorymodel.Example63()
+	// Output:
+	// sequenced: [first second]
+	// synchronized: [A B]
+}
+
+func ExampleExample64() {
+	memorymodel.Example64()
+	// Output:
+	// results: [1 2 3]
+	// wait: task failed
+}
+
+func ExampleExample65() {
+	memorymodel.Example65()
+	// Output:
+	// packed: 4000 stride 8 bytes
+	// padded: 4000 stride 64 bytes
+}
+
+func ExampleExample66() {
+	memorymodel.Example66()
+	// Output: received 12 items, sum 66
+}
+
+func ExampleExample67Fixed() {
+	memorymodel.Example67Fixed()
+	// Output: map[hello world:100]
+}
+
+func ExampleExample68() {
+	memorymodel.Example68()
+	// Output: [0 0 0 0 1 1 1 1 2 2]
+}
+
+func ExampleExample69() {
+	memorymodel.Example69()
+	// Output: unique ids: 100
+}
+
+func ExampleExample70() {
+	memorymodel.Example70()
+	// Output:
+	// "hello world" true
+	// "" false
+}
+
+func ExampleExample71() {
+	memorymodel.Example71()
+	// Output:
+	// [HELLO WORLD HELLO WORLD HELLO WORLD HELLO WORLD HELLO WORLD]
+	// computed: 1 cached: HELLO WORLD
+}
+
+func ExampleExample72() {
+	memorymodel.Example72()
+	// Output: hello world 1
+}
+
+func ExampleExample73() {
+	memorymodel.Example73()
+	// Output: middle <nil>
+}
+
+func ExampleExample74() {
+	memorymodel.Example74()
+	// Output:
+	// error: task failed
+	// recovered: panic: hello world
+}
+
+func ExampleExample75() {
+	memorymodel.Example75()
+	// Output: [5 5 2 6 5]
+}
+
+func ExampleExample76() {
+	memorymodel.Example76()
+	// Output: [0 1 4 9 16]
+}
+
+func ExampleExample77() {
+	memorymodel.Example77()
+	// Output:
+	// torn reads: 0
+	// final config: v3 3
+}
+
+func ExampleExample78() {
+	memorymodel.Example78()
+	// Output: count: 6
+}
+
+func ExampleExample79() {
+	memorymodel.Example79()
+	// Output:
+	// hello: 5
+	// initializations: 2
+}
+
+func ExampleExample80Deadlock() {
+	memorymodel.Example80Deadlock()
+	// Output: deadlock detected: true
+}
+
+func ExampleExample80Fixed() {
+	memorymodel.Example80Fixed()
+	// Output: total balance: 200
+}
+
+func ExampleExample81() {
+	memorymodel.Example81()
+	// Output: calls: 1
+}
+
+func ExampleExample82() {
+	memorymodel.Example82()
+	// Output:
+	// handle request: load user: query: context deadline exceeded
+	// deadline exceeded: true
+}
+
+func ExampleExample83() {
+	memorymodel.Example83()
+	// Output: len: 3 contains go: true contains memory: false
+}
+
+func ExampleExample84() {
+	memorymodel.Example84()
+	// Output:
+	// copies: [1000 1000 1000] original: 0
+	// locked shared: 3000
+}
+
+func ExampleExample85() {
+	memorymodel.Example85()
+	// Output:
+	// high 1
+	// high 2
+	// low 1
+	// low 2
+}
+
+func ExampleExample86() {
+	memorymodel.Example86()
+	// Output:
+	// producer handing off
+	// consumer received
+	// consumer finished
+	// producer resumed
+	// buffer: hello world
+}
+
+func ExampleExample87() {
+	memorymodel.Example87()
+	// Output:
+	// graceful: processed 5 of 5
+	// abrupt: processed 0 of 5
+}
+
+func ExampleExample88() {
+	memorymodel.Example88()
+	// Output:
+	// recovered: sync/atomic: store of inconsistently typed value into Value
+	// after concurrent stores: *memorymodel.Config
+}
+
+func ExampleExample89() {
+	memorymodel.Example89()
+	// Output:
+	// early [hello memory model]
+	// late [memory model]
+}
+
+func ExampleExample91() {
+	memorymodel.Example91()
+	// Output: calls: 3 error: <nil>
+}
+
+func ExampleExample92() {
+	memorymodel.Example92()
+	// Output: 4000
+}
+
+func ExampleExample93() {
+	memorymodel.Example93()
+	// Output:
+	// hello 5 true
+	// world 0 false
+	// go 2 true
+	// len: 2
+}
@@ -0,0 +1,84 @@
+package memorymodel
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Guarded holds a value and tracks who accesses it, to make the racy teaching examples
+// self-checking. When built with the race detector, Set also writes the calling goroutine to
+// a plain last-writer field and Get reads that field and records itself as the last reader
+// through an atomic. Both then yield the processor to widen the window for another goroutine.
+// An unsynchronized Set paired with another Get or Set is therefore reported by
+// `go test -race` on the last-writer field, while concurrent Gets never race with each other.
+// Without the race detector a Guarded is a plain holder and adds no overhead.
+// Like the value it wraps, a Guarded does no synchronization of its own, so callers that share
+// it between goroutines must synchronize their accesses
+type Guarded[T any] struct {
+	value  T
+	access accessTrack
+}
+
+// Get returns the held value
+func (g *Guarded[T]) Get() T {
+	g.access.read()
+	return g.value
+}
+
+// Set replaces the held value
+func (g *Guarded[T]) Set(v T) {
+	g.access.write()
+	g.value = v
+}
+
+// goroutineID returns the id of the calling goroutine, as printed in stack traces. It is
+// slow and only meant for diagnostics
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// guardedRacyCount is racyCount with the counter held in a Guarded
+func guardedRacyCount(n int) int {
+	var count Guarded[int]
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, k := range []int{n / 2, n - n/2} {
+		go func(k int) {
+			defer wg.Done()
+			for range k {
+				count.Set(count.Get() + 1) // data race
+			}
+		}(k)
+	}
+	wg.Wait()
+	return count.Get()
+}
+
+// guardedLockedCount is guardedRacyCount with every access made under a mutex
+func guardedLockedCount(n int) int {
+	var mu sync.Mutex
+	var count Guarded[int]
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, k := range []int{n / 2, n - n/2} {
+		go func(k int) {
+			defer wg.Done()
+			for range k {
+				mu.Lock()
+				count.Set(count.Get() + 1)
+				mu.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	return count.Get()
+}
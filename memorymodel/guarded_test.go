@@ -0,0 +1,86 @@
+package memorymodel
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// guardedHelperEnv makes TestGuardedRacyHelper run the racy counter instead of skipping
+const guardedHelperEnv = "GOBLOGS_GUARDED_RACY_HELPER"
+
+func TestGuardedHolder(t *testing.T) {
+	var g Guarded[string]
+	if v := g.Get(); v != "" {
+		t.Errorf("zero Guarded holds %q, want the zero value", v)
+	}
+	g.Set("hello world")
+	if v := g.Get(); v != "hello world" {
+		t.Errorf("Get() = %q, want %q", v, "hello world")
+	}
+}
+
+func TestGuardedLockedCount(t *testing.T) {
+	// fails under -race if the synchronized version is reported
+	if got := guardedLockedCount(200); got != 200 {
+		t.Errorf("count = %d, want 200", got)
+	}
+}
+
+// TestGuardedDetectsRace runs the racy counter from Example10Racy wrapped in a Guarded in a
+// child process, since a detected race fails the test that triggered it
+func TestGuardedDetectsRace(t *testing.T) {
+	if !raceEnabled {
+		t.Skip("the race detector is not enabled")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGuardedRacyHelper$", "-test.count=1")
+	cmd.Env = append(os.Environ(), guardedHelperEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("racy helper passed, want the race detector to fail it:\n%s", out)
+	}
+	if !strings.Contains(string(out), "WARNING: DATA RACE") {
+		t.Errorf("race detector did not report a data race:\n%s", out)
+	}
+}
+
+func TestGuardedRacyHelper(t *testing.T) {
+	if os.Getenv(guardedHelperEnv) == "" {
+		t.Skip("only run as a child process of TestGuardedDetectsRace")
+	}
+	guardedRacyCount(100)
+}
+
+func TestGuardedConcurrentReaders(t *testing.T) {
+	// fails under -race if reads that overlap under a read lock are reported
+	var mu sync.RWMutex
+	var g Guarded[string]
+	g.Set("hello world")
+
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for range 8 {
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				mu.RLock()
+				if v := g.Get(); v != "hello world" {
+					t.Errorf("Get() = %q, want %q", v, "hello world")
+				}
+				mu.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGoroutineID(t *testing.T) {
+	main := goroutineID()
+	other := make(chan int64)
+	go func() { other <- goroutineID() }()
+	if id := <-other; main <= 0 || id <= 0 || id == main {
+		t.Errorf("goroutine ids %d and %d, want two distinct positive ids", main, id)
+	}
+}
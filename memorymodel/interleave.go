@@ -0,0 +1,48 @@
+package memorymodel
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+// Interleave runs each step in its own goroutine and, once every step has returned, reports
+// whether invariant holds
+// All goroutines are released at once from a shared start gate, and each step yields the
+// processor a random 1 to 3 times before it runs and again after. The counts are drawn afresh
+// on every call, so repeated calls tend to exercise different interleavings of the steps
+//
+// Limitations:
+//   - runtime.Gosched is only a hint to the scheduler. Interleave encourages variety but
+//     cannot force or enumerate a particular schedule, so a passing run proves nothing about
+//     schedules that were not exercised
+//   - Interleave does not detect data races itself. Run it under `go test -race` to have the
+//     race detector check the schedules that did occur
+//   - invariant runs after all steps have returned, which is synchronized by a WaitGroup. It
+//     can safely read anything the steps wrote, but cannot observe intermediate states
+//   - yields only happen around steps, never inside them. To interleave at a finer grain,
+//     split the work into more, smaller steps
+func Interleave(invariant func() bool, steps ...func()) bool {
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(len(steps))
+	for _, step := range steps {
+		before, after := rand.IntN(3)+1, rand.IntN(3)+1
+		go func() {
+			defer wg.Done()
+			<-start
+			yield(before)
+			step()
+			yield(after)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return invariant()
+}
+
+func yield(n int) {
+	for range n {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,29 @@
+package memorymodel
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// TestInterleaveExample10Fixed runs the atomicCount behind Example10Fixed as several
+// concurrent steps, so the increments of different calls interleave as well
+func TestInterleaveExample10Fixed(t *testing.T) {
+	for round := range 20 {
+		var total int64
+		steps := make([]func(), 10)
+		for i := range steps {
+			steps[i] = func() { atomic.AddInt64(&total, atomicCount(100)) }
+		}
+
+		invariant := func() bool { return atomic.LoadInt64(&total) == 1000 }
+		if !Interleave(invariant, steps...) {
+			t.Fatalf("round %d: total = %d, want 1000", round, atomic.LoadInt64(&total))
+		}
+	}
+}
+
+func TestInterleaveNoSteps(t *testing.T) {
+	if !Interleave(func() bool { return true }) {
+		t.Error("Interleave with no steps should report the invariant")
+	}
+}
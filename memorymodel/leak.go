@@ -0,0 +1,27 @@
+package memorymodel
+
+import (
+	"runtime"
+	"time"
+)
+
+// WaitForGoroutines polls runtime.NumGoroutine until it drops to baseline or timeout passes,
+// where baseline is typically a runtime.NumGoroutine() taken before starting the work being
+// checked. Goroutines that were just told to stop may take a moment to exit, so the count is
+// polled with a short backoff. It returns the last count observed and whether it reached
+// baseline, which lets callers report a leak however suits them
+func WaitForGoroutines(baseline int, timeout time.Duration) (int, bool) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		n := runtime.NumGoroutine()
+		if n <= baseline {
+			return n, true
+		}
+		if time.Now().After(deadline) {
+			return n, false
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 50*time.Millisecond)
+	}
+}
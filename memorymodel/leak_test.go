@@ -0,0 +1,99 @@
+package memorymodel
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// leakTimeout is how long AssertNoLeak waits for goroutines to exit
+const leakTimeout = time.Second
+
+// AssertNoLeak fails t if the number of running goroutines does not return to baseline
+// within leakTimeout. It is the testing.TB wrapper around WaitForGoroutines the tests in
+// this package use
+func AssertNoLeak(t testing.TB, baseline int) {
+	t.Helper()
+	if n, ok := WaitForGoroutines(baseline, leakTimeout); !ok {
+		t.Errorf("%d goroutine(s) leaked: %d running, want %d", n-baseline, n, baseline)
+	}
+}
+
+// settledGoroutines returns runtime.NumGoroutine once it has stopped changing. Goroutines of
+// earlier tests that already called wg.Done may still be exiting, and a baseline taken while
+// they are counted would hide a goroutine leaked after it
+func settledGoroutines(t testing.TB) int {
+	t.Helper()
+	deadline := time.Now().Add(leakTimeout)
+	n, stable := runtime.NumGoroutine(), 0
+	for stable < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine count did not settle, last seen %d", n)
+		}
+		time.Sleep(time.Millisecond)
+		if m := runtime.NumGoroutine(); m == n {
+			stable++
+		} else {
+			n, stable = m, 0
+		}
+	}
+	return n
+}
+
+// recordingTB is a testing.TB that records failures instead of reporting them
+type recordingTB struct {
+	testing.TB
+	failed bool
+	msg    string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failed = true
+	r.msg = fmt.Sprintf(format, args...)
+}
+
+func TestAssertNoLeakDetectsLeak(t *testing.T) {
+	baseline := settledGoroutines(t)
+	block := make(chan struct{})
+	go func() { <-block }()
+
+	var rec recordingTB
+	AssertNoLeak(&rec, baseline)
+	if !rec.failed {
+		t.Error("AssertNoLeak did not detect the blocked goroutine")
+	}
+	t.Log(rec.msg)
+
+	close(block)
+	AssertNoLeak(t, baseline)
+}
+
+func TestAssertNoLeakClean(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	done := make(chan struct{})
+	go func() { close(done) }()
+	<-done
+
+	var rec recordingTB
+	AssertNoLeak(&rec, baseline)
+	if rec.failed {
+		t.Errorf("AssertNoLeak reported a leak: %s", rec.msg)
+	}
+}
+
+func TestWaitForGoroutines(t *testing.T) {
+	baseline := settledGoroutines(t)
+	block := make(chan struct{})
+	go func() { <-block }()
+
+	if n, ok := WaitForGoroutines(baseline, 20*time.Millisecond); ok || n < baseline+1 {
+		t.Errorf("WaitForGoroutines = %d, %v with a blocked goroutine, want >= %d, false", n, ok, baseline+1)
+	}
+	close(block)
+	if n, ok := WaitForGoroutines(baseline, leakTimeout); !ok {
+		t.Errorf("WaitForGoroutines = %d, %v after unblocking, want <= %d, true", n, ok, baseline)
+	}
+}
@@ -2,12 +2,29 @@ package memorymodel
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Link - https://go.dev/ref/mem
 
+// Output is where the examples write what they print. It defaults to standard output and can
+// be replaced, e.g. with a bytes.Buffer, to inspect what an example produced
+var Output io.Writer = stdout{}
+
+// stdout writes to whatever os.Stdout is at the time of the write rather than at package
+// initialization, so redirecting os.Stdout (as go test does for examples) still works
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
 // The go statement that starts a new goroutine is synchronized before the start of the
 // goroutine's execution
 // In following example go routine will print "bye world" because the main goroutine will
@@ -15,20 +32,118 @@ import (
 func Example1() {
 	var a string = "hello world"
 	go func() {
-		fmt.Println(a)
+		fmt.Fprintln(Output, a)
 	}()
 	a = "bye world"
 	time.Sleep(1 * time.Second) // wait to let goroutine print value of a
 }
 
+// The same as Example1, but the main goroutine waits on a done channel instead of sleeping
+// Everything before the go statement is synchronized before the goroutine starts, so it is
+// guaranteed to print "hello world". Closing done after the print is synchronized before
+// <-done returns, so Example1Sync returns as soon as the goroutine has printed
+func Example1Sync() {
+	var a string = "hello world"
+	done := make(chan struct{})
+	go func() {
+		fmt.Fprintln(Output, a)
+		close(done)
+	}()
+	<-done
+}
+
 // The exit of a goroutine is NOT guranteed to be synchronized before any event in the program
 // Here a will be printed as "empty" because assignment by go routine is not followed by any
 // synchronization event
 // In this example, an aggressive compiler might just delete the whole go statement
 func Example2() {
+	fmt.Fprintln(Output, Example2Value())
+}
+
+// Example2Value runs Example2 and returns the value of a instead of printing it, so the
+// outcome can be observed with Stress or reproduced with RunWithSeed
+func Example2Value() string {
 	var a string = "empty"
-	go func() { a = "hello world" }()
-	fmt.Println(a)
+	go func() {
+		schedPoint()
+		a = "hello world"
+	}()
+	schedPoint()
+	return a
+}
+
+// The go statement is synchronized before the start of the goroutine it creates, but that is
+// all it promises. Goroutines started one after the other in a loop are not started, let
+// alone run, in that order: the scheduler is free to pick any runnable goroutine, and in
+// practice often runs the most recently created one first. Code that needs goroutines to go
+// in a particular order has to synchronize them explicitly, as in Example24
+func Example90() {
+	fmt.Fprintln(Output, startOrder(10))
+}
+
+// startOrder starts n goroutines in order and returns the order in which they began running
+func startOrder(n int) []int {
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return order
+}
+
+// Within a single goroutine, statements are sequenced before each other in program order,
+// and that goroutine always observes its own effects in that order. Across goroutines there
+// is no order at all unless a synchronizing operation, such as a channel send and the receive
+// that completes it, makes one event synchronized before the other. Happens before is made
+// of these two relations: sequenced before within a goroutine, synchronized before between
+// goroutines
+func Example63() {
+	fmt.Fprintln(Output, "sequenced:", sequencedOrder())
+	fmt.Fprintln(Output, "synchronized:", crossGoroutineOrder(true))
+}
+
+// sequencedOrder records two events from the same goroutine
+func sequencedOrder() []string {
+	var rec Recorder
+	rec.Event("first")
+	rec.Event("second")
+	return rec.Events()
+}
+
+// crossGoroutineOrder records "A" in one goroutine and "B" in another. Goroutine A is started
+// first but is slow to record its event. When synchronized is true, A sends on a channel after
+// recording and B records only after the receive, so "A" is always recorded first. Otherwise
+// the events may be recorded in either order
+func crossGoroutineOrder(synchronized bool) []string {
+	var rec Recorder
+	c := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond)
+		rec.Event("A")
+		if synchronized {
+			c <- struct{}{}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if synchronized {
+			<-c
+		}
+		rec.Event("B")
+	}()
+	wg.Wait()
+	return rec.Events()
 }
 
 // A. Channel Communication
@@ -46,7 +161,7 @@ func Example3() {
 		c <- 0
 	}()
 	<-c
-	fmt.Println(a)
+	fmt.Fprintln(Output, a)
 }
 
 // Similar to Example3, the closing of a channel is synchronized before a receive
@@ -59,9 +174,9 @@ func Example4() {
 		a = "hello world"
 		close(c)
 	}()
-	fmt.Println(<-c) // closed channel send 0 value
-	fmt.Println(<-c) // recurring calls on a closed channel returns 0
-	fmt.Println(a)
+	fmt.Fprintln(Output, <-c) // closed channel send 0 value
+	fmt.Fprintln(Output, <-c) // recurring calls on a closed channel returns 0
+	fmt.Fprintln(Output, a)
 }
 
 // A receive from an unbuffered channel is synchronized before the completion of the
@@ -74,31 +189,328 @@ func Example5() {
 		<-c
 	}()
 	c <- 0
-	fmt.Println(a)
+	fmt.Fprintln(Output, a)
 }
 
 type FuncType func(x int)
 
-// The kth receive on a channel with capacity C is synchronized before the completion of the
-// k+Cth send from that channel completes.
+// The kth receive on a channel with capacity C is synchronized before the k+Cth send on that
+// channel completes.
 func Example6() {
-	work := make([]FuncType, 10)
+	RunLimited(WithSink(Output))
+}
+
+// Option configures RunLimited
+type Option func(*limitConfig)
+
+type limitConfig struct {
+	workers     int
+	concurrency int
+	out         io.Writer
+	work        FuncType
+}
+
+// WithWorkers sets the number of work functions to run. The default is 10, and a value
+// below 0 is treated as 0, which runs nothing
+func WithWorkers(n int) Option {
+	return func(c *limitConfig) { c.workers = n }
+}
+
+// WithConcurrency sets how many work functions may run at the same time. The default is 3,
+// and a value below 1 is treated as 1 so the work still runs, one function at a time
+func WithConcurrency(n int) Option {
+	return func(c *limitConfig) { c.concurrency = n }
+}
+
+// WithSink sets where the default work function prints. The default is Output
+func WithSink(w io.Writer) Option {
+	return func(c *limitConfig) { c.out = w }
+}
+
+// WithWork replaces the default work function, which prints its index to the sink
+func WithWork(f FuncType) Option {
+	return func(c *limitConfig) { c.work = f }
+}
+
+// RunLimited runs Example6 with the given options. It returns once every work function has
+// completed, and never runs more than the configured concurrency at once
+func RunLimited(opts ...Option) {
+	c := limitConfig{workers: 10, concurrency: 3, out: Output}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.workers = max(c.workers, 0)
+	c.concurrency = max(c.concurrency, 1) // an unbuffered limit would block every worker
+
+	work := make([]FuncType, c.workers)
 
 	for i := range work {
-		work[i] = func(x int) { fmt.Println("work function: ", x) }
+		work[i] = c.work
+		if work[i] == nil {
+			work[i] = func(x int) { fmt.Fprintln(c.out, "work function: ", x) }
+		}
 	}
 
-	limit := make(chan int, 3) // buffered channel
+	limit := make(chan int, c.concurrency) // buffered channel
+	var wg sync.WaitGroup
+	wg.Add(len(work))
 	for i, w := range work {
-		go func(w FuncType) {
+		// i and w are passed as arguments so each goroutine gets its own copy. Since Go 1.22
+		// loop variables are per-iteration anyway, but passing them keeps the example correct
+		// regardless of the language version
+		go func(i int, w FuncType) {
 			// goroutines coordinate using the limit channel to ensure at any given point
-			// there are at most 3 work functions running
+			// there are at most c.concurrency work functions running
 			limit <- 1
 			w(i)
 			<-limit
-		}(w)
+			wg.Done()
+		}(i, w)
+	}
+	wg.Wait() // wait for all work functions to finish
+}
+
+// Closing a channel is synchronized before every receive that returns because the channel is
+// closed, which makes close a one-to-many broadcast
+// All receivers block on <-done until the single close(done) releases them at once, and each
+// of them is guaranteed to observe the write to a made before the close
+func Example22() {
+	for _, v := range broadcast(3) {
+		fmt.Fprintln(Output, v)
 	}
-	time.Sleep(1 * time.Second)
+}
+
+// broadcast releases n waiting goroutines with a single close and returns what each observed
+func broadcast(n int) []string {
+	done := make(chan struct{})
+	var a string
+
+	seen := make([]string, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			<-done
+			seen[i] = a
+		}(i)
+	}
+
+	a = "hello world"
+	close(done)
+	wg.Wait()
+	return seen
+}
+
+// The same handoff behaves differently over a buffered and an unbuffered channel
+// On an unbuffered channel the receive is synchronized before the send completes, so anything
+// the consumer did before receiving happens before the producer continues past its send.
+// On a buffered channel with free capacity the send completes immediately, and the producer
+// usually continues long before the consumer gets around to receiving
+func Example44() {
+	fmt.Fprintln(Output, "unbuffered:", handoffOrder(0))
+	fmt.Fprintln(Output, "buffered:", handoffOrder(1))
+}
+
+// handoffOrder sends one value over a channel with the given capacity to a consumer that
+// takes a moment before receiving, and returns the order of the recorded events
+func handoffOrder(capacity int) []string {
+	var rec Recorder
+	c := make(chan int, capacity)
+	done := make(chan struct{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond) // a consumer that is slow to get to the receive
+		rec.Event("consumer ready")
+		<-c
+		close(done)
+	}()
+
+	c <- 0
+	rec.Event("producer sent")
+	<-done
+	return rec.Events()
+}
+
+// A range loop over a channel receives until the channel is closed and then terminates.
+// This is how a consumer learns that a producer is done. A bare receive, as in Example4, cannot
+// tell a closed channel from a zero value without the two-value form v, ok := <-c, and keeps
+// returning zero values forever
+func Example53() {
+	for _, v := range rangeUntilClosed(1, 2, 3) {
+		fmt.Fprintln(Output, v)
+	}
+	fmt.Fprintln(Output, "range loop done")
+}
+
+// rangeUntilClosed sends vals from a producer goroutine that closes the channel afterwards
+// and returns what a ranging consumer received
+func rangeUntilClosed(vals ...int) []int {
+	c := make(chan int)
+	go func() {
+		for _, v := range vals {
+			c <- v
+		}
+		close(c) // the close is synchronized before the receive that ends the range loop
+	}()
+
+	var got []int
+	for v := range c {
+		got = append(got, v)
+	}
+	return got
+}
+
+// A goroutine that needs its own copy of some state should receive it as an argument. The
+// arguments are evaluated in the calling goroutine as part of the go statement, which is
+// synchronized before the new goroutine starts, so each goroutine gets the value at the time
+// it was started. A goroutine that instead reads a variable shared with its creator sees
+// whatever the variable holds when it gets around to reading it, which is the bug Example6
+// guards against. Since Go 1.22 a for loop declares a fresh variable per iteration, so the
+// sharing has to be written out explicitly to reproduce it
+func Example58() {
+	fmt.Fprintln(Output, "parameter:", len(distinct(startWithParameter(10))), "distinct values")
+}
+
+// The same goroutines reading the shared variable usually see repeated values, often the
+// final value of i, which is out of range for the loop
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example58Shared() {
+	fmt.Fprintln(Output, "shared:", len(distinct(startWithShared(10))), "distinct values")
+}
+
+// startWithParameter starts n goroutines, passing each its index, and returns what each saw
+func startWithParameter(n int) []int {
+	seen := make([]int, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			seen[i] = i
+		}(i)
+	}
+	wg.Wait()
+	return seen
+}
+
+// startWithShared starts n goroutines that all read one variable shared with the loop, the
+// way loop variables behaved before Go 1.22, and returns what each saw
+func startWithShared(n int) []int {
+	seen := make([]int, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	var i int // one variable for all iterations
+	for i = 0; i < n; i++ {
+		go func(slot int) {
+			defer wg.Done()
+			seen[slot] = i // data race with the loop's i++
+		}(i)
+	}
+	wg.Wait()
+	return seen
+}
+
+// distinct returns the distinct values of vals
+func distinct(vals []int) map[int]bool {
+	set := make(map[int]bool)
+	for _, v := range vals {
+		set[v] = true
+	}
+	return set
+}
+
+// The kth receive on a channel with capacity C is synchronized before the k+Cth send on that
+// channel completes. A producer can therefore get at most C values
+// ahead of its consumer, which is what lets a buffered channel act as a counting semaphore in
+// Example6. Here the consumer is slow and the producer fills the buffer quickly, yet the send
+// of value k+C never completes before the consumer has started receiving value k
+func Example61() {
+	const capacity, n = 2, 5
+	events := capacityOrder(capacity, n)
+	for k := 0; k+capacity < n; k++ {
+		fmt.Fprintf(Output, "receive %d before send %d completed: %v\n", k, k+capacity,
+			receivedBeforeSent(events, k, k+capacity))
+	}
+}
+
+// capacityOrder sends 0..n-1 over a channel with the given capacity to a slow consumer and
+// returns the recorded events. The consumer records "receive k" immediately before its kth
+// receive, since anything sequenced before the receive is also synchronized before the
+// k+Cth send completes, and the producer records "sent k" once its kth send has completed
+func capacityOrder(capacity, n int) []string {
+	var rec Recorder
+	c := make(chan int, capacity)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for k := range n {
+			time.Sleep(time.Millisecond)
+			rec.Event(fmt.Sprintf("receive %d", k))
+			<-c
+		}
+	}()
+
+	for k := range n {
+		c <- k
+		rec.Event(fmt.Sprintf("sent %d", k))
+	}
+	<-done
+	return rec.Events()
+}
+
+// receivedBeforeSent reports whether "receive recv" was recorded before "sent sent" in events
+func receivedBeforeSent(events []string, recv, sent int) bool {
+	r := slices.Index(events, fmt.Sprintf("receive %d", recv))
+	s := slices.Index(events, fmt.Sprintf("sent %d", sent))
+	return r >= 0 && s >= 0 && r < s
+}
+
+// A pointer sent on a channel publishes everything written through it before the send. The
+// producer fills in every field and then sends the pointer; the send is synchronized before
+// the receive completes, so the consumer sees a fully populated struct without any further
+// synchronization. The producer must not touch the struct after the send
+func Example72() {
+	c := publishViaChannel()
+	fmt.Fprintln(Output, c.Name, c.Version)
+}
+
+// Publishing the same pointer through a plain shared variable races: the consumer may see the
+// pointer before it sees the fields written through it, and with nothing ordering the
+// accesses the compiler and CPU are free to reorder them
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example72Shared() {
+	c := publishViaShared()
+	fmt.Fprintln(Output, c.Name, c.Version)
+}
+
+// publishViaChannel builds a Config in a producer goroutine and hands it over on a channel
+func publishViaChannel() *Config {
+	ch := make(chan *Config)
+	go func() {
+		c := new(Config)
+		c.Name = "hello world"
+		c.Version = 1
+		ch <- c // every write above is visible to the receiver
+	}()
+	return <-ch
+}
+
+// publishViaShared builds a Config in a producer goroutine and hands it over through a plain
+// variable that the consumer polls
+func publishViaShared() *Config {
+	var shared *Config
+	go func() {
+		c := new(Config)
+		c.Name = "hello world"
+		c.Version = 1
+		shared = c // data race with the reads below
+	}()
+	for shared == nil {
+		runtime.Gosched()
+	}
+	return shared
 }
 
 // B. Locks
@@ -107,16 +519,974 @@ func Example6() {
 // For any sync.Mutex or sync.RWMutex variable l and n < m
 // call n o l.Unlock() is synchronized before call m of l.Lock() returns
 func Example7() {
+	example7(nil)
+}
+
+// example7 runs Example7, recording the write and the print in rec if it is not nil
+func example7(rec *Recorder) {
 	var l sync.Mutex
 	var a string
 
 	var f = func() {
 		a = "hello world"
+		rec.Event("write")
 		l.Unlock() // the first call to l.Unlock() is synchronized before the second call to l.Lock() returns
 	}
 
 	l.Lock()
 	go f()
-	l.Lock()       // the second call to l.Lock() is sequenced before the print statement
-	fmt.Println(a) // a is guaranteed to print "hello world"
+	l.Lock() // the second call to l.Lock() is sequenced before the print statement
+	rec.Event("print")
+	fmt.Fprintln(Output, a) // a is guaranteed to print "hello world"
+}
+
+// For any call to l.RLock on a sync.RWMutex variable l, there is an n such that the nth call
+// to l.Unlock is synchronized before the return from l.RLock, and the matching call to
+// l.RUnlock is synchronized before the return from call n+1 to l.Lock
+// The writer holds the lock while the readers start. Its Unlock is synchronized before their
+// RLock calls return, so every reader prints "hello world"
+func Example19() {
+	for _, v := range readAfterWrite(3) {
+		fmt.Fprintln(Output, v)
+	}
+}
+
+// readAfterWrite starts readers goroutines that block on RLock while a write is in progress
+// and returns the value each of them read
+func readAfterWrite(readers int) []string {
+	var l sync.RWMutex
+	var a string
+
+	l.Lock()
+	seen := make([]string, readers)
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	for i := range readers {
+		go func(i int) {
+			defer wg.Done()
+			l.RLock() // returns only after the writer's Unlock
+			seen[i] = a
+			l.RUnlock()
+		}(i)
+	}
+	a = "hello world"
+	l.Unlock()
+
+	wg.Wait()
+	return seen
+}
+
+// Lock ordering
+// A goroutine that holds one lock while waiting for another can deadlock with a goroutine
+// that takes the same two locks in the opposite order: each holds the lock the other is
+// waiting for, and neither can proceed. The discipline that rules this out is a single global
+// order for all locks that are ever held together. Every goroutine acquires them in that
+// order, whatever order the operation names them in, so the goroutine holding the lowest
+// ranked lock can always make progress. Releases may happen in any order
+
+// Example80Deadlock transfers money between two accounts in both directions at once, locking
+// the source account first. Both goroutines take their first lock and then wait for the
+// other's; lockTimeout gives up after a while so the deadlock is reported instead of hanging
+func Example80Deadlock() {
+	fmt.Fprintln(Output, "deadlock detected:", abbaDeadlock(10*time.Millisecond))
+}
+
+// Example80Fixed does the same transfers, locking the account with the lower rank first
+func Example80Fixed() {
+	fmt.Fprintln(Output, "total balance:", orderedTransfers(100))
+}
+
+// account is a balance guarded by a mutex. rank fixes the order in which accounts are locked
+type account struct {
+	mu      sync.Mutex
+	rank    int
+	balance int
+}
+
+// lockTimeout tries to lock mu until d has passed and reports whether it succeeded
+func lockTimeout(mu *sync.Mutex, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for !mu.TryLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	return true
+}
+
+// abbaDeadlock runs two opposite transfers that each lock their source account first, and
+// reports whether either gave up waiting for its second lock after timeout
+func abbaDeadlock(timeout time.Duration) bool {
+	a, b := &account{rank: 1, balance: 100}, &account{rank: 2, balance: 100}
+	var holdFirst, wg sync.WaitGroup
+	holdFirst.Add(2)
+	wg.Add(2)
+	var timedOut atomic.Int64
+
+	move := func(from, to *account) {
+		defer wg.Done()
+		from.mu.Lock()
+		defer from.mu.Unlock()
+		holdFirst.Done()
+		holdFirst.Wait() // make sure both goroutines hold their first lock
+		if !lockTimeout(&to.mu, timeout) {
+			timedOut.Add(1)
+			return
+		}
+		defer to.mu.Unlock()
+		from.balance -= 10
+		to.balance += 10
+	}
+	go move(a, b)
+	go move(b, a)
+	wg.Wait()
+	return timedOut.Load() > 0
+}
+
+// transfer moves amount from one account to the other, locking both in rank order
+func transfer(from, to *account, amount int) {
+	first, second := from, to
+	if second.rank < first.rank {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	from.balance -= amount
+	to.balance += amount
+}
+
+// orderedTransfers runs n transfers in each direction between two accounts at once and
+// returns their total balance afterwards
+func orderedTransfers(n int) int {
+	a, b := &account{rank: 1, balance: 100}, &account{rank: 2, balance: 100}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, dir := range [][2]*account{{a, b}, {b, a}} {
+		go func(from, to *account) {
+			defer wg.Done()
+			for range n {
+				transfer(from, to, 10)
+			}
+		}(dir[0], dir[1])
+	}
+	wg.Wait()
+	return a.balance + b.balance
+}
+
+// C. Once
+// The sync package provides a safe mechanism for initialization in the presence of multiple
+// goroutines through the use of the Once type
+
+// Config is a shared configuration used by the examples that publish an initialized value
+// to other goroutines
+type Config struct {
+	Name    string
+	Version int
+}
+
+// The completion of a single call of f() from once.Do(f) is synchronized before the return
+// of any call of once.Do(f)
+// Every goroutine below prints "hello world" because whichever goroutine runs setup, its
+// writes are synchronized before once.Do returns in all the others
+func Example8() {
+	seen, _ := loadConfigOnce(3)
+	for _, c := range seen {
+		fmt.Fprintln(Output, c.Name)
+	}
+}
+
+// loadConfigOnce has n goroutines race to initialize a shared config with once.Do and returns
+// the config each goroutine observed along with the number of times setup ran
+func loadConfigOnce(n int) (seen []*Config, setups int) {
+	var once sync.Once
+	var config *Config
+
+	setup := func() {
+		setups++ // only ever executed by one goroutine
+		config = &Config{Name: "hello world", Version: 1}
+	}
+
+	seen = make([]*Config, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			once.Do(setup)
+			seen[i] = config // guaranteed to be fully initialized
+		}(i)
+	}
+	wg.Wait()
+	return seen, setups
+}
+
+// Since Go 1.21 sync.OnceFunc and sync.OnceValue wrap a function so that it runs at most
+// once. They give the same guarantee as once.Do: the completion of the wrapped function is
+// synchronized before any call to the returned function returns
+// Every goroutine below prints the value computed by the single call of setup
+func Example35() {
+	values, setups := callOnceValue(3)
+	for _, v := range values {
+		fmt.Fprintln(Output, v)
+	}
+	fmt.Fprintln(Output, "setup calls:", setups)
+}
+
+// callOnceValue calls a sync.OnceValue wrapped function from n goroutines and returns the
+// value each goroutine got along with the number of times the wrapped function ran
+func callOnceValue(n int) (values []string, setups int64) {
+	var calls atomic.Int64
+	get := sync.OnceValue(func() string {
+		calls.Add(1)
+		return "hello world"
+	})
+
+	values = make([]string, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			values[i] = get()
+		}(i)
+	}
+	wg.Wait()
+	return values, calls.Load()
+}
+
+// callOnceFunc calls a sync.OnceFunc wrapped function from n goroutines and returns the
+// number of times the wrapped function ran. Each goroutine checks that the write made by the
+// wrapped function is visible once its call returns
+func callOnceFunc(n int) (setups int64, allSaw bool) {
+	var calls atomic.Int64
+	var a string
+	setup := sync.OnceFunc(func() {
+		calls.Add(1)
+		a = "hello world"
+	})
+
+	var missed atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			setup()
+			if a != "hello world" {
+				missed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return calls.Load(), missed.Load() == 0
+}
+
+// Two correct ways to initialize a shared resource lazily, exactly once
+// sync.Once is the simple one. An atomic.Pointer fast path with a mutex-guarded slow path is
+// the fixed version of Example15Broken: the Store publishing the pointer is synchronized before
+// any Load that observes it, so a non-nil pointer is always fully constructed. The atomic
+// version saves little over sync.Once, whose fast path is already a single atomic load, and is
+// easier to get wrong, so prefer sync.Once unless a benchmark says otherwise
+func Example57() {
+	var inits atomic.Int64
+	create := func() *Config {
+		inits.Add(1)
+		return &Config{Name: "hello world", Version: 1}
+	}
+
+	o := &onceLazy{create: create}
+	a := &atomicLazy{create: create}
+	fmt.Fprintln(Output, "sync.Once instances:", countInstances(lazyGetAll(10, o.get)))
+	fmt.Fprintln(Output, "atomic.Pointer instances:", countInstances(lazyGetAll(10, a.get)))
+	fmt.Fprintln(Output, "initializations:", inits.Load())
+}
+
+// onceLazy lazily creates a config with sync.Once
+type onceLazy struct {
+	once   sync.Once
+	create func() *Config
+	config *Config
+}
+
+func (l *onceLazy) get() *Config {
+	l.once.Do(func() { l.config = l.create() })
+	return l.config
+}
+
+// atomicLazy lazily creates a config with an atomic.Pointer fast path
+type atomicLazy struct {
+	mu     sync.Mutex
+	create func() *Config
+	config atomic.Pointer[Config]
+}
+
+func (l *atomicLazy) get() *Config {
+	if c := l.config.Load(); c != nil {
+		return c // fast path: already initialized
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.config.Load(); c != nil {
+		return c // another goroutine initialized it while we waited for the lock
+	}
+	c := l.create()
+	l.config.Store(c)
+	return c
+}
+
+// sync.Once initializes one thing. To initialize one thing per key, store a lazily
+// initialized wrapper instead of the value: LoadOrStore settles which wrapper wins for a key,
+// and the wrapper's sync.OnceValue runs init once, however many callers got that wrapper back.
+// Callers that lose the LoadOrStore race only wasted a wrapper, never a call to init
+func Example79() {
+	var m sync.Map
+	var inits atomic.Int64
+	var wg sync.WaitGroup
+	for range 3 {
+		for _, key := range []string{"hello", "world"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				GetOrInit(&m, key, func() int {
+					inits.Add(1)
+					return len(key)
+				})
+			}()
+		}
+	}
+	wg.Wait()
+	fmt.Fprintln(Output, "hello:", GetOrInit(&m, "hello", func() int { return -1 }))
+	fmt.Fprintln(Output, "initializations:", inits.Load())
+}
+
+// GetOrInit returns the value for key in m, calling init to create it if there is none yet.
+// init runs at most once per key, even when several goroutines ask for a missing key at the
+// same time, and all of them get the value from that one call. m must only be accessed
+// through GetOrInit with the same K and V
+func GetOrInit[K comparable, V any](m *sync.Map, key K, init func() V) V {
+	if e, ok := m.Load(key); ok {
+		return e.(func() V)() // fast path: no wrapper allocated
+	}
+	e, _ := m.LoadOrStore(key, sync.OnceValue(init))
+	return e.(func() V)()
+}
+
+// D. Atomic Values
+// The APIs in the sync/atomic package are collectively "atomic operations" that can be used
+// to synchronize the execution of different goroutines
+
+// If the effect of an atomic operation A is observed by atomic operation B, then A is
+// synchronized before B
+// Here the producer writes a plain (non-atomic) string and then stores to the ready flag.
+// Once the consumer's Load observes that store, the write to data is guaranteed to be visible,
+// so "hello world" is always printed
+func Example9() {
+	fmt.Fprintln(Output, publishAtomic("hello world"))
+}
+
+// publishAtomic hands payload from a producer goroutine to the calling goroutine using only
+// an atomic flag for synchronization
+func publishAtomic(payload string) string {
+	var data string
+	var ready atomic.Int32
+
+	go func() {
+		data = payload // plain write, sequenced before the atomic store
+		ready.Store(1)
+	}()
+
+	for ready.Load() == 0 { // spin until the store is observed
+		runtime.Gosched()
+	}
+	return data
+}
+
+// A background loop polls a stop flag that the main goroutine sets. With a plain bool the
+// write and the reads would race, and the compiler would be free to hoist the read out of the
+// loop so that it never sees the update. An atomic.Bool makes the Store and the Loads
+// synchronize: once a Load observes true, the loop also sees everything written before Store
+func Example49() {
+	iterations, reason := stopLoop(10 * time.Millisecond)
+	fmt.Fprintln(Output, "loop ran:", iterations > 0)
+	fmt.Fprintln(Output, "loop stopped:", reason)
+}
+
+// stopLoop runs a loop until the main goroutine sets a stop flag after d. It returns how many
+// iterations the loop ran and the reason for stopping as observed by the loop
+func stopLoop(d time.Duration) (iterations int, reason string) {
+	var stop atomic.Bool
+	var why string
+	type result struct {
+		n   int
+		why string
+	}
+	done := make(chan result)
+
+	go func() {
+		n := 0
+		for !stop.Load() {
+			n++
+			runtime.Gosched()
+		}
+		done <- result{n, why} // why is visible because the Load observed the Store
+	}()
+
+	time.Sleep(d)
+	why = "shutdown requested" // plain write, published by the Store below
+	stop.Store(true)
+	r := <-done
+	return r.n, r.why
+}
+
+// An atomic Add is a single read-modify-write, so concurrent callers can never observe the
+// same old value: every caller gets a distinct result, and the results handed out increase in
+// the order the Adds happened. Example69 hands out IDs to many goroutines at once
+func Example69() {
+	var gen idGenerator
+	ids := make([][]uint64, 10)
+	var wg sync.WaitGroup
+	wg.Add(len(ids))
+	for g := range ids {
+		go func(g int) {
+			defer wg.Done()
+			for range 10 {
+				ids[g] = append(ids[g], gen.next())
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	unique := make(map[uint64]bool)
+	for _, batch := range ids {
+		for _, id := range batch {
+			unique[id] = true
+		}
+	}
+	fmt.Fprintln(Output, "unique ids:", len(unique))
+}
+
+// defaultIDs is the generator behind NextID
+var defaultIDs idGenerator
+
+// NextID returns a new ID, unique and greater than every ID returned before it, starting at
+// 1. It is safe to call from any number of goroutines
+// Like all unsigned arithmetic in Go, the underlying counter wraps around silently: after
+// 1<<64 - 1 the next ID is 0 and uniqueness is lost. At a billion IDs per second that takes
+// over 500 years, so it is not checked
+func NextID() uint64 {
+	return defaultIDs.next()
+}
+
+// idGenerator hands out sequence numbers. The zero value is ready to use and its first
+// number is 1
+type idGenerator struct {
+	last atomic.Uint64
+}
+
+func (g *idGenerator) next() uint64 {
+	return g.last.Add(1)
+}
+
+// E. Data Races
+// A data race is a write to a memory location happening concurrently with another read or
+// write to that same location, unless all the accesses involved are atomic data accesses
+
+// Two goroutines increment a shared int without any synchronization. The increments race, so
+// updates may be lost and the printed count can be anything up to 1000
+// This example is for teaching only: it is expected to fail under `go test -race` and is
+// excluded from normal verification
+func Example10Racy() {
+	fmt.Fprintln(Output, racyCount(1000))
+}
+
+// The same counter using atomic.AddInt64. Atomic operations never race with each other, so
+// the count is always exactly 1000
+func Example10Fixed() {
+	fmt.Fprintln(Output, atomicCount(1000))
+}
+
+// racyCount splits n increments of a plain int across two goroutines
+func racyCount(n int) int {
+	var count int
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, k := range []int{n / 2, n - n/2} {
+		go func(k int) {
+			defer wg.Done()
+			for range k {
+				count++ // data race
+			}
+		}(k)
+	}
+	wg.Wait()
+	return count
+}
+
+// atomicCount splits n atomic increments across two goroutines
+func atomicCount(n int) int64 {
+	var count int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, k := range []int{n / 2, n - n/2} {
+		go func(k int) {
+			defer wg.Done()
+			for range k {
+				atomic.AddInt64(&count, 1)
+			}
+		}(k)
+	}
+	wg.Wait()
+	return count
+}
+
+// Each element of a slice is a separate memory location, so goroutines that write to
+// disjoint parts of the same backing array do not race, and no lock is needed. Here the slice
+// is cut into non-overlapping sub-slices, one per goroutine, and wg.Wait makes all of their
+// writes visible to the goroutine that reads the assembled result
+func Example68() {
+	fmt.Fprintln(Output, fillPartitioned(10, 3, 0))
+}
+
+// When the partitions overlap, two goroutines write the elements they share and the writes
+// race. Which partition number survives at each boundary depends on the schedule
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example68Overlapping() {
+	fmt.Fprintln(Output, fillPartitioned(10, 3, 1))
+}
+
+// fillPartitioned splits a slice of n elements into parts partitions, each extended by
+// overlap elements into the next one, and has a separate goroutine write its partition number
+// to every element of its partition
+func fillPartitioned(n, parts, overlap int) []int {
+	data := make([]int, n)
+	size := (n + parts - 1) / parts
+	var wg sync.WaitGroup
+	for p := range parts {
+		lo, hi := p*size, min((p+1)*size+overlap, n)
+		if lo >= hi {
+			break
+		}
+		wg.Add(1)
+		go func(p int, part []int) {
+			defer wg.Done()
+			for i := range part {
+				part[i] = p
+			}
+		}(p, data[lo:hi])
+	}
+	wg.Wait()
+	return data
+}
+
+// append reads a slice's length and capacity, may allocate a new backing array, writes the
+// new element and produces a new slice header. Several goroutines appending to one shared
+// slice race on all of that, so elements get lost or overwritten. The racy version is
+// Example76Racy in teaching.go, which is only built with `-tags teaching`. The fix is to give
+// the slice a single owner: every goroutine sends its result on a channel, and only the
+// collecting goroutine appends
+func Example76() {
+	got := collectResults(5, func(i int) int { return i * i })
+	slices.Sort(got) // results arrive in no particular order
+	fmt.Fprintln(Output, got)
+}
+
+// collectResults runs f(i) for every i in [0, n) in its own goroutine and returns the
+// results, in the order they arrived
+func collectResults(n int, f func(int) int) []int {
+	results := make(chan int)
+	for i := range n {
+		go func(i int) {
+			results <- f(i)
+		}(i)
+	}
+	var collected []int
+	for range n { // exactly one receive per goroutine, so none is left blocked
+		collected = append(collected, <-results)
+	}
+	return collected
+}
+
+// A method with a value receiver works on a copy of its receiver, made when the method is
+// called. Each goroutine below increments its own copy of the counter, so there is no shared
+// memory to race on, and the original is never changed. The catch is that the copies are all
+// the method changes: the goroutines' increments are not added up anywhere
+func Example84() {
+	copies, original := valueCopies(3, 1000)
+	fmt.Fprintln(Output, "copies:", copies, "original:", original)
+	fmt.Fprintln(Output, "locked shared:", lockedShared(3, 1000))
+}
+
+// The same increments through a pointer receiver all go to one shared counter, and without a
+// lock they race and lose updates
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example84Shared() {
+	fmt.Fprintln(Output, "shared:", pointerShared(3, 1000))
+}
+
+// counter is a count with both a value and a pointer receiver method
+type counter struct {
+	n int
+}
+
+// plus returns a copy of c with k added, leaving c alone
+func (c counter) plus(k int) counter {
+	c.n += k
+	return c
+}
+
+// add adds k to c in place
+func (c *counter) add(k int) {
+	c.n += k
+}
+
+// lockedCounter is a counter whose pointer receiver method holds a lock
+type lockedCounter struct {
+	mu sync.Mutex
+	c  counter
+}
+
+func (l *lockedCounter) add(k int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.c.add(k)
+}
+
+// valueCopies has goroutines goroutines each increment their own copy of a counter n times.
+// It returns each copy's count and the original's
+func valueCopies(goroutines, n int) (copies []int, original int) {
+	var c counter
+	copies = make([]int, goroutines)
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int, mine counter) { // mine is a copy of c
+			defer wg.Done()
+			for range n {
+				mine = mine.plus(1)
+			}
+			copies[g] = mine.n
+		}(g, c)
+	}
+	wg.Wait()
+	return copies, c.n
+}
+
+// pointerShared has goroutines goroutines increment one shared counter n times each
+func pointerShared(goroutines, n int) int {
+	var c counter
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for range goroutines {
+		go func() {
+			defer wg.Done()
+			for range n {
+				c.add(1) // data race
+			}
+		}()
+	}
+	wg.Wait()
+	return c.n
+}
+
+// lockedShared is pointerShared with a lockedCounter
+func lockedShared(goroutines, n int) int {
+	var l lockedCounter
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for range goroutines {
+		go func() {
+			defer wg.Done()
+			for range n {
+				l.add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return l.c.n
+}
+
+// F. Incorrect Synchronization
+// A read r may observe the value written by a write w that happens concurrently with r. Even
+// if this occurs, it does not imply that reads happening after r will observe writes that
+// happened before w
+
+// Double-checked locking tries to avoid the cost of locking on the fast path by checking a
+// plain flag first. It is broken: observing initialized == true does not guarantee observing
+// the writes made inside setup, so a goroutine can return a half-constructed (or nil) config
+// This example is for teaching only: it is expected to fail under `go test -race`
+func Example15Broken() {
+	var l lazyBroken
+	fmt.Fprintln(Output, "instances:", countInstances(lazyGetAll(10, l.get)))
+}
+
+// The same pattern with an atomic.Bool flag. The Store in setup is synchronized before any
+// Load that observes it, so the fast path sees a fully constructed config
+func Example15Correct() {
+	var l lazyCorrect
+	fmt.Fprintln(Output, "instances:", countInstances(lazyGetAll(10, l.get)))
+}
+
+// lazyBroken lazily creates a config using a plain bool as the fast-path flag
+type lazyBroken struct {
+	mu          sync.Mutex
+	initialized bool
+	config      *Config
+}
+
+func (l *lazyBroken) get() *Config {
+	if !l.initialized { // unsynchronized read, races with the write below
+		l.mu.Lock()
+		if !l.initialized {
+			l.config = &Config{Name: "hello world", Version: 1}
+			l.initialized = true
+		}
+		l.mu.Unlock()
+	}
+	return l.config
+}
+
+// lazyCorrect lazily creates a config using an atomic.Bool as the fast-path flag
+type lazyCorrect struct {
+	mu          sync.Mutex
+	initialized atomic.Bool
+	config      *Config
+}
+
+func (l *lazyCorrect) get() *Config {
+	if !l.initialized.Load() {
+		l.mu.Lock()
+		if !l.initialized.Load() {
+			l.config = &Config{Name: "hello world", Version: 1}
+			l.initialized.Store(true)
+		}
+		l.mu.Unlock()
+	}
+	return l.config
+}
+
+// lazyGetAll calls get from n goroutines at once and returns what each one observed
+func lazyGetAll(n int, get func() *Config) []*Config {
+	seen := make([]*Config, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			seen[i] = get()
+		}(i)
+	}
+	wg.Wait()
+	return seen
+}
+
+// countInstances returns the number of distinct configs in seen
+func countInstances(seen []*Config) int {
+	distinct := make(map[*Config]bool)
+	for _, c := range seen {
+		distinct[c] = true
+	}
+	return len(distinct)
+}
+
+// Dekker's algorithm tries to achieve mutual exclusion with two flags: each goroutine raises
+// its own flag and only enters the critical section if the other flag is still down. With
+// plain variables nothing orders a goroutine's store before its subsequent load as seen by
+// the other goroutine, so both can read the stale 0 and enter together
+// This example is for teaching only: it is expected to fail under `go test -race`
+func Example33() {
+	fmt.Fprintln(Output, "runs with both goroutines inside:", dekkerViolations(dekkerPlain, 10000))
+}
+
+// Go's atomic operations behave like sequentially consistent atomics: all of them appear to
+// execute in some single total order. At least one goroutine's Load comes after the other's
+// Store in that order, so the two goroutines can never both enter
+func Example33Fixed() {
+	fmt.Fprintln(Output, "runs with both goroutines inside:", dekkerViolations(dekkerAtomic, 10000))
+}
+
+// dekkerPlain runs one round of Dekker's entry protocol with plain flags and returns how many
+// goroutines entered the critical section
+func dekkerPlain() int {
+	var x, y int
+	var entered atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		x = 1
+		if y == 0 { // data race with the write to y
+			entered.Add(1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		y = 1
+		if x == 0 {
+			entered.Add(1)
+		}
+	}()
+	wg.Wait()
+	return int(entered.Load())
+}
+
+// dekkerAtomic runs one round of Dekker's entry protocol with atomic flags
+func dekkerAtomic() int {
+	var x, y atomic.Int32
+	var entered atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		x.Store(1)
+		if y.Load() == 0 {
+			entered.Add(1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		y.Store(1)
+		if x.Load() == 0 {
+			entered.Add(1)
+		}
+	}()
+	wg.Wait()
+	return int(entered.Load())
+}
+
+// dekkerViolations runs round n times and counts the runs in which both goroutines entered
+func dekkerViolations(round func() int, n int) int {
+	violations := 0
+	for range n {
+		if round() == 2 {
+			violations++
+		}
+	}
+	return violations
+}
+
+// runtime.Gosched yields the processor so other goroutines can run, but it is only a hint to
+// the scheduler and is not a synchronizing operation: nothing that happens in another
+// goroutine while this one is yielded is ordered before what this goroutine does next. Here
+// the reader yields once before reading, which makes it much more likely that the writer has
+// run by then, but the read still races with the write and may observe "empty". Adding
+// Gosched to racy code changes how often it goes wrong, never whether it can
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example67() {
+	fmt.Fprintln(Output, Stress(100, goschedRead))
+}
+
+// The writer publishes with an atomic Store after writing, and the reader waits for the Load
+// that observes it before reading. The Store is synchronized before that Load, so the read
+// always observes "hello world". The reader still yields while it waits, but only to let the
+// writer run; correctness comes from the atomic flag
+func Example67Fixed() {
+	fmt.Fprintln(Output, Stress(100, goschedAtomicRead))
+}
+
+// goschedRead reads a variable written by another goroutine after yielding once
+func goschedRead() string {
+	a := "empty"
+	go func() {
+		a = "hello world" // data race with the read below
+	}()
+	runtime.Gosched()
+	return a
+}
+
+// goschedAtomicRead is goschedRead with the write published through an atomic flag
+func goschedAtomicRead() string {
+	a := "empty"
+	var ready atomic.Bool
+	go func() {
+		a = "hello world"
+		ready.Store(true)
+	}()
+	for !ready.Load() {
+		runtime.Gosched()
+	}
+	return a
+}
+
+// G. Initialization
+// Program initialization runs in a single goroutine. Within a package, all package-level
+// variables are initialized before any init function runs, and importing packages only start
+// their own initialization once the imported package is fully initialized. main.main starts
+// after all init functions have completed
+
+// initOrder records initialization events. It has no initializer, so it holds its zero value
+// before any of the variables below are initialized
+var initOrder []string
+
+var (
+	initFirst  = recordInit("var initFirst")
+	initSecond = recordInit("var initSecond")
+)
+
+func init() {
+	recordInit("init")
+}
+
+func recordInit(event string) string {
+	initOrder = append(initOrder, event)
+	return event
+}
+
+// InitOrder returns the initialization events of this package in the order they happened
+func InitOrder() []string {
+	return append([]string(nil), initOrder...)
+}
+
+// The package-level variables are always initialized before init runs
+func Example17() {
+	for _, event := range InitOrder() {
+		fmt.Fprintln(Output, event)
+	}
+}
+
+// H. Finalizers
+// A call to runtime.SetFinalizer(x, f) is synchronized before the finalization call f(x).
+// Nothing else orders a finalizer with the rest of the program: it runs on its own goroutine
+// at some unspecified time after x becomes unreachable, or possibly never. A finalizer that
+// needs to tell the program it ran must use explicit synchronization, such as a channel
+
+// Example34 drops the last reference to an object with a finalizer and forces garbage
+// collections until the finalizer reports over a channel that it ran
+func Example34() {
+	name, ok := awaitFinalizer(time.Second)
+	fmt.Fprintln(Output, "finalized:", name, ok)
+}
+
+// resource is an object with a finalizer
+type resource struct {
+	name string
+}
+
+// awaitFinalizer creates a resource with a finalizer, makes it unreachable and waits up to
+// timeout for its finalizer to run. It returns the name the finalizer observed
+func awaitFinalizer(timeout time.Duration) (string, bool) {
+	finalized := make(chan string, 1) // buffered so the finalizer never blocks
+	func() {
+		r := &resource{name: "hello world"}
+		runtime.SetFinalizer(r, func(r *resource) {
+			finalized <- r.name // the write to name is visible thanks to SetFinalizer
+		})
+	}() // r is unreachable once this function returns
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(10 * time.Millisecond) // one ticker for every retry of the GC
+	defer tick.Stop()
+	for {
+		runtime.GC()
+		select {
+		case name := <-finalized:
+			return name, true
+		case <-deadline.C:
+			return "", false
+		case <-tick.C:
+		}
+	}
 }
@@ -0,0 +1,612 @@
+package memorymodel
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer that is safe to write to from several goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureOutput runs f with Output redirected to a buffer and returns what was written
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	var out lockedBuffer
+	saved := Output
+	Output = &out
+	t.Cleanup(func() { Output = saved })
+	f()
+	return out.String()
+}
+
+func TestExample1Sync(t *testing.T) {
+	start := time.Now()
+	got := captureOutput(t, Example1Sync)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Example1Sync took %v, want it to return promptly", elapsed)
+	}
+	if got != "hello world\n" {
+		t.Errorf("Example1Sync printed %q, want %q", got, "hello world\n")
+	}
+}
+
+func TestExample3Output(t *testing.T) {
+	if got := captureOutput(t, Example3); got != "hello world\n" {
+		t.Errorf("Example3 printed %q, want %q", got, "hello world\n")
+	}
+}
+
+func TestExample5Output(t *testing.T) {
+	if got := captureOutput(t, Example5); got != "hello world\n" {
+		t.Errorf("Example5 printed %q, want %q", got, "hello world\n")
+	}
+}
+
+func TestExample6Indices(t *testing.T) {
+	var out lockedBuffer
+	start := time.Now()
+	RunLimited(WithSink(&out))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("RunLimited took %v, want well under a second", elapsed)
+	}
+
+	var got []int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var x int
+		if _, err := fmt.Sscanf(line, "work function:  %d", &x); err != nil {
+			t.Fatalf("unexpected line %q: %v", line, err)
+		}
+		got = append(got, x)
+	}
+	slices.Sort(got)
+
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !slices.Equal(got, want) {
+		t.Errorf("got indices %v, want %v", got, want)
+	}
+}
+
+func TestRunLimitedConcurrency(t *testing.T) {
+	var inFlight, peak, ran atomic.Int64
+	RunLimited(WithWorkers(100), WithConcurrency(7), WithWork(func(int) {
+		raiseMax(&peak, inFlight.Add(1))
+		time.Sleep(time.Millisecond)
+		inFlight.Add(-1)
+		ran.Add(1)
+	}))
+
+	if n := ran.Load(); n != 100 {
+		t.Errorf("%d work functions ran, want 100", n)
+	}
+	if n := peak.Load(); n > 7 {
+		t.Errorf("%d work functions ran at once, want at most 7", n)
+	}
+}
+
+func TestRunLimitedClampsOptions(t *testing.T) {
+	for _, tc := range []struct{ workers, concurrency, ran int }{
+		{10, 0, 10},
+		{10, -3, 10},
+		{0, 3, 0},
+		{-1, 3, 0},
+	} {
+		var inFlight, peak, ran atomic.Int64
+		ok := completesWithin(time.Second, func() {
+			RunLimited(WithWorkers(tc.workers), WithConcurrency(tc.concurrency), WithWork(func(int) {
+				raiseMax(&peak, inFlight.Add(1))
+				inFlight.Add(-1)
+				ran.Add(1)
+			}))
+		})
+		if !ok {
+			t.Fatalf("RunLimited(workers %d, concurrency %d) did not return", tc.workers, tc.concurrency)
+		}
+		if n := ran.Load(); n != int64(tc.ran) {
+			t.Errorf("workers %d, concurrency %d: %d work functions ran, want %d", tc.workers, tc.concurrency, n, tc.ran)
+		}
+		if n := peak.Load(); n > 1 && tc.concurrency < 1 {
+			t.Errorf("concurrency %d: %d work functions ran at once, want at most 1", tc.concurrency, n)
+		}
+	}
+}
+
+func TestExample8InitializesOnce(t *testing.T) {
+	seen, setups := loadConfigOnce(100)
+	if setups != 1 {
+		t.Errorf("setup ran %d times, want 1", setups)
+	}
+	for i, c := range seen {
+		if c == nil || c != seen[0] {
+			t.Fatalf("goroutine %d observed config %p, want %p", i, c, seen[0])
+		}
+		if c.Name != "hello world" || c.Version != 1 {
+			t.Errorf("goroutine %d observed partially initialized config %+v", i, *c)
+		}
+	}
+}
+
+func TestExample9PublishesPayload(t *testing.T) {
+	for i := range 1000 {
+		want := fmt.Sprintf("payload %d", i)
+		if got := publishAtomic(want); got != want {
+			t.Fatalf("consumer read %q, want %q", got, want)
+		}
+	}
+}
+
+func TestExample10Racy(t *testing.T) {
+	if raceEnabled {
+		t.Skip("racyCount contains a deliberate data race")
+	}
+	if got := racyCount(1000); got < 1 || got > 1000 {
+		t.Errorf("racy count = %d, want a value in [1, 1000]", got)
+	}
+}
+
+func TestExample10Fixed(t *testing.T) {
+	if got := atomicCount(1000); got != 1000 {
+		t.Errorf("atomic count = %d, want 1000", got)
+	}
+}
+
+func TestExample15Correct(t *testing.T) {
+	for range 100 {
+		var l lazyCorrect
+		seen := lazyGetAll(50, l.get)
+		if n := countInstances(seen); n != 1 {
+			t.Fatalf("observed %d distinct configs, want 1", n)
+		}
+		if c := seen[0]; c == nil || c.Name != "hello world" || c.Version != 1 {
+			t.Fatalf("observed partially constructed config %v", c)
+		}
+	}
+}
+
+func TestExample17InitOrder(t *testing.T) {
+	want := []string{"var initFirst", "var initSecond", "init"}
+	if got := InitOrder(); !slices.Equal(got, want) {
+		t.Errorf("InitOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestExample19ReadersObserveWrite(t *testing.T) {
+	for range 100 {
+		for i, v := range readAfterWrite(20) {
+			if v != "hello world" {
+				t.Fatalf("reader %d read %q, want %q", i, v, "hello world")
+			}
+		}
+	}
+}
+
+func TestExample22Broadcast(t *testing.T) {
+	seen := broadcast(50)
+	if len(seen) != 50 {
+		t.Fatalf("%d goroutines reported, want 50", len(seen))
+	}
+	for i, v := range seen {
+		if v != "hello world" {
+			t.Errorf("goroutine %d observed %q, want %q", i, v, "hello world")
+		}
+	}
+}
+
+func TestExample33Fixed(t *testing.T) {
+	if n := dekkerViolations(dekkerAtomic, 20000); n != 0 {
+		t.Errorf("both goroutines entered the critical section in %d runs, want 0", n)
+	}
+}
+
+func TestExample34Finalizer(t *testing.T) {
+	name, ok := awaitFinalizer(5 * time.Second)
+	if !ok {
+		t.Fatal("finalizer did not run")
+	}
+	if name != "hello world" {
+		t.Errorf("finalizer observed %q, want %q", name, "hello world")
+	}
+}
+
+func TestExample35OnceValue(t *testing.T) {
+	values, setups := callOnceValue(100)
+	if setups != 1 {
+		t.Errorf("wrapped function ran %d times, want 1", setups)
+	}
+	for i, v := range values {
+		if v != "hello world" {
+			t.Errorf("goroutine %d got %q, want %q", i, v, "hello world")
+		}
+	}
+}
+
+func TestExample35OnceFunc(t *testing.T) {
+	setups, allSaw := callOnceFunc(100)
+	if setups != 1 {
+		t.Errorf("wrapped function ran %d times, want 1", setups)
+	}
+	if !allSaw {
+		t.Error("a goroutine returned from the OnceFunc without observing its write")
+	}
+}
+
+func TestExample44Unbuffered(t *testing.T) {
+	want := []string{"consumer ready", "producer sent"}
+	for range 20 {
+		if got := handoffOrder(0); !slices.Equal(got, want) {
+			t.Fatalf("unbuffered handoff recorded %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExample44Buffered(t *testing.T) {
+	// the buffered order is not guaranteed either way, but with a slow consumer the producer
+	// should get ahead at least once
+	want := []string{"producer sent", "consumer ready"}
+	for range 20 {
+		if got := handoffOrder(1); slices.Equal(got, want) {
+			return
+		}
+	}
+	t.Errorf("buffered handoff never let the producer proceed before the consumer")
+}
+
+func TestExample49StopFlag(t *testing.T) {
+	start := time.Now()
+	iterations, reason := stopLoop(20 * time.Millisecond)
+	if iterations == 0 {
+		t.Error("loop never ran")
+	}
+	if reason != "shutdown requested" {
+		t.Errorf("loop observed reason %q, want %q", reason, "shutdown requested")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("loop took %v to stop", elapsed)
+	}
+}
+
+func TestExample53RangeStopsOnClose(t *testing.T) {
+	want := []int{0, 7, 0, 9} // zero values are delivered like any other value
+	if got := rangeUntilClosed(want...); !slices.Equal(got, want) {
+		t.Errorf("range received %v, want %v", got, want)
+	}
+	if got := rangeUntilClosed(); len(got) != 0 {
+		t.Errorf("range over an empty stream received %v", got)
+	}
+}
+
+func TestExample57LazyInit(t *testing.T) {
+	for name, newGet := range map[string]func(create func() *Config) func() *Config{
+		"sync.Once":      func(create func() *Config) func() *Config { return (&onceLazy{create: create}).get },
+		"atomic.Pointer": func(create func() *Config) func() *Config { return (&atomicLazy{create: create}).get },
+	} {
+		for range 50 {
+			var inits atomic.Int64
+			get := newGet(func() *Config {
+				inits.Add(1)
+				return &Config{Name: "hello world", Version: 1}
+			})
+			seen := lazyGetAll(50, get)
+			if n := inits.Load(); n != 1 {
+				t.Fatalf("%s: initialized %d times, want 1", name, n)
+			}
+			if n := countInstances(seen); n != 1 {
+				t.Fatalf("%s: observed %d distinct configs, want 1", name, n)
+			}
+			if c := seen[0]; c.Name != "hello world" || c.Version != 1 {
+				t.Fatalf("%s: observed partially constructed config %+v", name, *c)
+			}
+		}
+	}
+}
+
+func newTestConfig() *Config { return &Config{Name: "hello world", Version: 1} }
+
+func BenchmarkLazyOnce(b *testing.B) {
+	l := &onceLazy{create: newTestConfig}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.get()
+		}
+	})
+}
+
+func BenchmarkLazyAtomic(b *testing.B) {
+	l := &atomicLazy{create: newTestConfig}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.get()
+		}
+	})
+}
+
+func TestExample58Parameter(t *testing.T) {
+	for range 20 {
+		seen := startWithParameter(100)
+		for i, v := range seen {
+			if v != i {
+				t.Fatalf("goroutine %d saw %d, want %d", i, v, i)
+			}
+		}
+		if n := len(distinct(seen)); n != 100 {
+			t.Fatalf("saw %d distinct values, want 100", n)
+		}
+	}
+}
+
+func TestExample61CapacityRule(t *testing.T) {
+	for _, capacity := range []int{1, 2, 4} {
+		const n = 8
+		events := capacityOrder(capacity, n)
+		for k := 0; k+capacity < n; k++ {
+			if !receivedBeforeSent(events, k, k+capacity) {
+				t.Errorf("capacity %d: send %d completed before receive %d started: %v",
+					capacity, k+capacity, k, events)
+			}
+		}
+	}
+}
+
+func TestExample63Sequenced(t *testing.T) {
+	want := []string{"first", "second"}
+	for range 100 {
+		if got := sequencedOrder(); !slices.Equal(got, want) {
+			t.Fatalf("recorded %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExample63Synchronized(t *testing.T) {
+	want := []string{"A", "B"}
+	for range 50 {
+		if got := crossGoroutineOrder(true); !slices.Equal(got, want) {
+			t.Fatalf("recorded %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExample63Unsynchronized(t *testing.T) {
+	// without the channel nothing orders the events, and B should get ahead at least once
+	want := []string{"B", "A"}
+	for range 50 {
+		if got := crossGoroutineOrder(false); slices.Equal(got, want) {
+			return
+		}
+	}
+	t.Error("unsynchronized events were always recorded in the order the goroutines started")
+}
+
+func TestExample68Disjoint(t *testing.T) {
+	// meant to be run with -race as well: the disjoint writes must not be reported
+	for _, tc := range []struct{ n, parts int }{{10, 3}, {100, 7}, {8, 8}, {5, 8}} {
+		got := fillPartitioned(tc.n, tc.parts, 0)
+		size := (tc.n + tc.parts - 1) / tc.parts
+		for i, v := range got {
+			if v != i/size {
+				t.Errorf("n=%d parts=%d: element %d = %d, want partition %d", tc.n, tc.parts, i, v, i/size)
+			}
+		}
+	}
+}
+
+func TestExample69UniqueIDs(t *testing.T) {
+	const goroutines, batch = 50, 200
+	ids := make([][]uint64, goroutines)
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range ids {
+		go func(g int) {
+			defer wg.Done()
+			for range batch {
+				ids[g] = append(ids[g], NextID())
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for g, got := range ids {
+		if !slices.IsSorted(got) {
+			t.Errorf("goroutine %d got IDs out of order", g)
+		}
+		for _, id := range got {
+			if seen[id] {
+				t.Fatalf("ID %d handed out twice", id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != goroutines*batch {
+		t.Errorf("got %d unique IDs, want %d", len(seen), goroutines*batch)
+	}
+}
+
+func TestExample69Wraparound(t *testing.T) {
+	var gen idGenerator
+	gen.last.Store(math.MaxUint64 - 1)
+	if id := gen.next(); id != math.MaxUint64 {
+		t.Errorf("next() = %d, want %d", id, uint64(math.MaxUint64))
+	}
+	if id := gen.next(); id != 0 {
+		t.Errorf("next() after the maximum = %d, want it to wrap around to 0", id)
+	}
+}
+
+func TestExample72ChannelPublishes(t *testing.T) {
+	for range 1000 {
+		c := publishViaChannel()
+		if c.Name != "hello world" || c.Version != 1 {
+			t.Fatalf("consumer observed partially populated config %+v", *c)
+		}
+	}
+}
+
+func TestExample76CollectResults(t *testing.T) {
+	for range 20 {
+		got := collectResults(100, func(i int) int { return i * 2 })
+		slices.Sort(got)
+		if len(got) != 100 {
+			t.Fatalf("collected %d results, want 100", len(got))
+		}
+		for i, v := range got {
+			if v != i*2 {
+				t.Fatalf("collected %v, want every f(i) exactly once", got)
+			}
+		}
+	}
+}
+
+// TestExample76RacyNeedsTeachingTag checks that teaching.go, which holds Example76Racy, is
+// left out of the default build and only compiled with -tags teaching
+func TestExample76RacyNeedsTeachingTag(t *testing.T) {
+	for _, tc := range []struct {
+		tags        []string
+		wantIgnored bool
+	}{
+		{nil, true},
+		{[]string{"teaching"}, false},
+	} {
+		ctx := build.Default
+		ctx.BuildTags = tc.tags
+		pkg, err := ctx.ImportDir(".", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignored := slices.Contains(pkg.IgnoredGoFiles, "teaching.go"); ignored != tc.wantIgnored {
+			t.Errorf("tags %v: teaching.go ignored = %v, want %v", tc.tags, ignored, tc.wantIgnored)
+		}
+		if built := slices.Contains(pkg.GoFiles, "teaching.go"); built == tc.wantIgnored {
+			t.Errorf("tags %v: teaching.go built = %v, want %v", tc.tags, built, !tc.wantIgnored)
+		}
+	}
+}
+
+func TestExample79GetOrInit(t *testing.T) {
+	var m sync.Map
+	var mu sync.Mutex
+	inits := make(map[int]int)
+	const keys, callers = 5, 100
+
+	var start, wg sync.WaitGroup
+	start.Add(1)
+	wg.Add(keys * callers)
+	for key := range keys {
+		for range callers {
+			go func() {
+				defer wg.Done()
+				start.Wait() // release all callers at once
+				v := GetOrInit(&m, key, func() string {
+					mu.Lock()
+					inits[key]++
+					mu.Unlock()
+					time.Sleep(time.Millisecond) // widen the window for concurrent callers
+					return fmt.Sprint("value ", key)
+				})
+				if want := fmt.Sprint("value ", key); v != want {
+					t.Errorf("GetOrInit(%d) = %q, want %q", key, v, want)
+				}
+			}()
+		}
+	}
+	start.Done()
+	wg.Wait()
+
+	for key := range keys {
+		if n := inits[key]; n != 1 {
+			t.Errorf("init for key %d ran %d times, want 1", key, n)
+		}
+	}
+}
+
+// completesWithin reports whether f returns within d. If it does not, f's goroutine is left
+// behind
+func completesWithin(d time.Duration, f func()) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestExample80DeadlockDetected(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	for range 5 {
+		if !abbaDeadlock(5 * time.Millisecond) {
+			t.Fatal("inverted lock order did not deadlock")
+		}
+	}
+	AssertNoLeak(t, baseline) // lockTimeout lets both goroutines give up and exit
+}
+
+func TestExample80OrderedCompletes(t *testing.T) {
+	for range 20 {
+		var total int
+		if !completesWithin(5*time.Second, func() { total = orderedTransfers(1000) }) {
+			t.Fatal("ordered transfers did not complete, deadlocked?")
+		}
+		if total != 200 {
+			t.Fatalf("total balance = %d, want 200", total)
+		}
+	}
+}
+
+func TestExample84ValueCopiesAreIsolated(t *testing.T) {
+	// every goroutine works on its own copy, so this is race free and nothing adds up
+	copies, original := valueCopies(8, 500)
+	for g, n := range copies {
+		if n != 500 {
+			t.Errorf("copy %d counted %d, want 500", g, n)
+		}
+	}
+	if original != 0 {
+		t.Errorf("original counter = %d, want it untouched", original)
+	}
+}
+
+func TestExample84LockedShared(t *testing.T) {
+	if got := lockedShared(8, 500); got != 4000 {
+		t.Errorf("locked shared count = %d, want 4000", got)
+	}
+}
+
+func TestExample90StartOrderNotFIFO(t *testing.T) {
+	for range 100 {
+		order := startOrder(10)
+		if len(order) != 10 {
+			t.Fatalf("%d goroutines started, want 10", len(order))
+		}
+		if !slices.IsSorted(order) {
+			return // the scheduler did not follow launch order
+		}
+	}
+	t.Error("goroutines always started in launch order across 100 runs")
+}
@@ -0,0 +1,1297 @@
+package memorymodel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Examples in this file are common concurrency patterns built from goroutines and channels.
+// Each one relies on the channel rules from memorymodel.go for its correctness
+
+// Goroutine leaks
+// A goroutine blocked forever on a channel operation is never garbage collected. Unlike
+// Example2, where the goroutine simply finishes unobserved, a leaked goroutine keeps its
+// stack and everything it references alive for the lifetime of the program
+
+// Example13Leak gives up on a lookup before it finishes. The goroutine doing the lookup then
+// blocks forever on its send because nobody will ever receive from ch
+func Example13Leak() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the caller has already given up
+	_, err := leakyQuery(ctx)
+	fmt.Fprintln(Output, "query:", err)
+}
+
+// Example13Fixed is the same lookup with a buffered result channel. The send always
+// completes, so the goroutine exits whether or not the caller is still waiting
+func Example13Fixed() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fixedQuery(ctx)
+	fmt.Fprintln(Output, "query:", err)
+}
+
+// slowLookup stands in for an expensive operation such as a network call
+func slowLookup() string {
+	time.Sleep(10 * time.Millisecond)
+	return "result"
+}
+
+func leakyQuery(ctx context.Context) (string, error) {
+	ch := make(chan string) // unbuffered: the send blocks until someone receives
+	go func() { ch <- slowLookup() }()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func fixedQuery(ctx context.Context) (string, error) {
+	ch := make(chan string, 1) // room for the result even if nobody receives it
+	go func() { ch <- slowLookup() }()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Pipelines
+// A pipeline is a series of stages connected by channels. Each stage receives values from
+// upstream, does some work and sends the results downstream
+
+// Example14 squares the numbers 1..5 using a fan-out of three workers reading from the same
+// input channel and a fan-in that merges their outputs into a single channel
+func Example14() {
+	sum := 0
+	for v := range squarePipeline(3, 1, 2, 3, 4, 5) {
+		sum += v
+	}
+	fmt.Fprintln(Output, sum)
+}
+
+// squarePipeline fans nums out to the given number of square workers and fans their results
+// back in. The results arrive in no particular order
+func squarePipeline(workers int, nums ...int) <-chan int {
+	in := Generator(nums...)
+	outs := make([]<-chan int, workers)
+	for i := range outs {
+		outs[i] = sq(in) // every worker reads from the same channel
+	}
+	return Merge(outs...)
+}
+
+// Generator sends nums on the returned channel from a new goroutine and closes it when done.
+// The goroutine that sends on a channel owns it and is the only one that closes it; returning
+// a receive-only channel lets the compiler enforce that consumers cannot send or close
+func Generator(nums ...int) <-chan int {
+	out := make(chan int)
+	go func() {
+		for _, n := range nums {
+			out <- n
+		}
+		close(out)
+	}()
+	return out
+}
+
+// Example52 consumes a generator until it is closed
+func Example52() {
+	for v := range Generator(3, 1, 4) {
+		fmt.Fprintln(Output, v)
+	}
+}
+
+// sq squares every value received from in until in is closed
+func sq(in <-chan int) <-chan int {
+	out := make(chan int)
+	go func() {
+		for n := range in {
+			out <- n * n
+		}
+		close(out)
+	}()
+	return out
+}
+
+// Merge forwards values from all chans to a single channel. The output is closed exactly
+// once, after every input has been drained and closed
+func Merge[T any](chans ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, c := range chans {
+		go func(c <-chan T) {
+			for v := range c {
+				out <- v
+			}
+			wg.Done()
+		}(c)
+	}
+	// closing out before every forwarding goroutine is done would panic on their next send
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Example36 merges three channels into one. Values from different inputs arrive interleaved
+// in no particular order, but every value arrives exactly once
+func Example36() {
+	sum := 0
+	for v := range Merge(source(1, 2), source(3, 4), source(5)) {
+		sum += v
+	}
+	fmt.Fprintln(Output, sum)
+}
+
+// Tee forwards every value received from in to both returned channels and closes both once
+// in is closed. Each value is delivered to both outputs before the next one is read, so a slow
+// consumer holds back the other one but neither ever misses a value
+func Tee[T any](in <-chan T) (<-chan T, <-chan T) {
+	out1 := make(chan T)
+	out2 := make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for v := range in {
+			// local copies so a finished output can be disabled by setting it to nil
+			o1, o2 := out1, out2
+			for range 2 {
+				select {
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// Example37 duplicates a channel with Tee and sums each copy independently
+func Example37() {
+	a, b := Tee(source(1, 2, 3, 4))
+	sums := make(chan int)
+	for _, c := range []<-chan int{a, b} {
+		go func(c <-chan int) {
+			sum := 0
+			for v := range c {
+				sum += v
+			}
+			sums <- sum
+		}(c)
+	}
+	fmt.Fprintln(Output, <-sums, <-sums)
+}
+
+// Select
+// If one or more of the communications in a select can proceed, a single one is chosen via a
+// uniform pseudo-random selection. Source order gives no priority
+
+// Example16 runs a select over two channels that are always ready and prints how often each
+// case was chosen. The counts come out roughly equal, and differ from run to run
+func Example16() {
+	a, b := selectCounts(10000)
+	fmt.Fprintln(Output, "a:", a, "b:", b)
+}
+
+// selectCounts runs n selects over two always-ready channels and counts the chosen cases
+func selectCounts(n int) (a, b int) {
+	chA := make(chan struct{})
+	chB := make(chan struct{})
+	close(chA) // a receive from a closed channel never blocks
+	close(chB)
+	for range n {
+		select {
+		case <-chA:
+			a++
+		case <-chB:
+			b++
+		}
+	}
+	return a, b
+}
+
+// A select with a default case never blocks: if no other case can proceed, default runs
+// immediately. This turns a send or a receive into a non-blocking attempt
+
+// Example47 offers three values to a channel with room for one and then polls it twice
+func Example47() {
+	ch := make(chan int, 1)
+	sent, dropped := 0, 0
+	for i := range 3 {
+		if trySend(ch, i) {
+			sent++
+		} else {
+			dropped++
+		}
+	}
+	fmt.Fprintln(Output, "sent:", sent, "dropped:", dropped)
+
+	for range 2 {
+		if v, ok := tryRecv(ch); ok {
+			fmt.Fprintln(Output, "received", v)
+		} else {
+			fmt.Fprintln(Output, "nothing ready")
+		}
+	}
+}
+
+// trySend sends v on ch if that can be done without blocking and reports whether it did
+func trySend(ch chan<- int, v int) bool {
+	select {
+	case ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// tryRecv receives from ch if a value is ready and reports whether it did
+func tryRecv(ch <-chan int) (int, bool) {
+	select {
+	case v := <-ch:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// dropWhenBusy offers n values to a consumer that takes delay to handle each one, dropping
+// the values the consumer is not ready for. It returns how many values were sent, dropped and
+// received, and how long the producer spent offering them
+func dropWhenBusy(n, capacity int, delay time.Duration) (sent, dropped, received int, producing time.Duration) {
+	ch := make(chan int, capacity)
+	done := make(chan int)
+	go func() {
+		count := 0
+		for range ch {
+			time.Sleep(delay)
+			count++
+		}
+		done <- count
+	}()
+
+	start := time.Now()
+	for i := range n {
+		if trySend(ch, i) {
+			sent++
+		} else {
+			dropped++
+		}
+	}
+	producing = time.Since(start)
+	close(ch)
+	return sent, dropped, <-done, producing
+}
+
+// Priorities
+// When several cases of a select are ready, one is chosen at random, so a select cannot
+// prefer one channel over another by itself. A non-blocking select on the preferred channel
+// first takes care of that: only if it has nothing ready does the receive fall back to a
+// blocking select on both. The preference only applies to values that are already waiting;
+// if both become ready while the fallback select is blocked, either may win
+
+// Example85 drains a high and a low priority queue that both have items waiting
+func Example85() {
+	high, low := make(chan string, 2), make(chan string, 2)
+	low <- "low 1"
+	high <- "high 1"
+	low <- "low 2"
+	high <- "high 2"
+	for range 4 {
+		fmt.Fprintln(Output, priorityRecv(high, low))
+	}
+}
+
+// priorityRecv receives from high if it has a value ready, and otherwise from whichever of
+// high and low is ready first
+func priorityRecv[T any](high, low <-chan T) T {
+	select {
+	case v := <-high:
+		return v
+	default:
+	}
+	select {
+	case v := <-high:
+		return v
+	case v := <-low:
+		return v
+	}
+}
+
+// Ping-pong
+// A send on an unbuffered channel completes only once the matching receive has happened, so
+// two goroutines passing a token over unbuffered channels take strictly alternating turns
+
+// Example24 bounces a token between two goroutines for three rounds
+func Example24() {
+	for _, turn := range pingPong(3) {
+		fmt.Fprintln(Output, turn)
+	}
+}
+
+// pingPong has players A and B pass a token back and forth for the given number of rounds and
+// returns the turns in the order they were taken
+func pingPong(rounds int) []string {
+	var rec Recorder
+	ping := make(chan int)
+	pong := make(chan int)
+	done := make(chan struct{})
+
+	go func() { // player A
+		for i := range rounds {
+			rec.Event("A")
+			ping <- i
+			<-pong
+		}
+		close(done)
+	}()
+	go func() { // player B
+		for range rounds {
+			i := <-ping
+			rec.Event("B")
+			pong <- i
+		}
+	}()
+
+	<-done
+	return rec.Events()
+}
+
+// Handoff with acknowledgment
+// Sending a pointer hands the resource it points to over to the receiver, but the sender
+// only learns that the receiver got it, not that the receiver is done with it. A second
+// channel in the other direction completes the handshake: the consumer acknowledges once it
+// has finished, and everything it did before the acknowledgment is visible to the producer
+// once the acknowledgment is received, so the producer can safely take the resource back
+
+// Example86 hands a buffer to a consumer, waits for the acknowledgment and reads what the
+// consumer wrote into it
+func Example86() {
+	contents, events := handoffWithAck()
+	for _, e := range events {
+		fmt.Fprintln(Output, e)
+	}
+	fmt.Fprintln(Output, "buffer:", contents)
+}
+
+// handoffWithAck passes a buffer from a producer to a consumer and back. It returns the
+// buffer's contents as the producer saw them after the acknowledgment and the recorded events
+func handoffWithAck() (contents string, events []string) {
+	var rec Recorder
+	handoff := make(chan *bytes.Buffer)
+	ack := make(chan struct{})
+
+	go func() { // consumer
+		buf := <-handoff
+		rec.Event("consumer received")
+		buf.WriteString("hello world") // the consumer owns the buffer until it acknowledges
+		rec.Event("consumer finished")
+		ack <- struct{}{}
+	}()
+
+	buf := new(bytes.Buffer)
+	rec.Event("producer handing off")
+	handoff <- buf
+	<-ack // the buffer is the producer's again
+	rec.Event("producer resumed")
+	return buf.String(), rec.Events()
+}
+
+// Worker pools
+// A fixed number of workers receive jobs from a shared channel. Unlike Example6, which starts
+// a goroutine per item and limits how many run at once, a pool bounds the number of
+// goroutines itself
+
+// Example28 doubles five jobs using a pool of two workers
+func Example28() {
+	sum := 0
+	for r := range workerPool(2, []int{1, 2, 3, 4, 5}, func(x int) int { return 2 * x }) {
+		sum += r.value
+	}
+	fmt.Fprintln(Output, sum)
+}
+
+// poolResult is the outcome of a single job
+type poolResult struct {
+	job   int
+	value int
+}
+
+// workerPool applies f to every job using the given number of workers. The returned channel
+// is closed once all results have been sent
+func workerPool(workers int, jobs []int, f func(int) int) <-chan poolResult {
+	jobCh := make(chan int)
+	results := make(chan poolResult)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				results <- poolResult{job: j, value: f(j)}
+			}
+		}()
+	}
+
+	go func() {
+		for _, j := range jobs {
+			jobCh <- j
+		}
+		close(jobCh) // lets the workers' range loops finish
+	}()
+	go func() {
+		wg.Wait()
+		close(results) // exactly once, after the last worker is done sending
+	}()
+	return results
+}
+
+// ParallelMap applies f to every element of in using at most concurrency goroutines and
+// returns the results in input order. Each result is written to its own index of the output
+// slice, so the workers never write the same element and need no lock, and wg.Wait makes
+// every write visible before the slice is returned
+func ParallelMap[T, U any](in []T, concurrency int, f func(T) U) []U {
+	out := make([]U, len(in))
+	workers := min(max(concurrency, 1), len(in))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				out[i] = f(in[i])
+			}
+		}()
+	}
+	for i := range in {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return out
+}
+
+// Example75 measures five words with two workers. The lengths come back in the order of the
+// words, whichever worker finished first
+func Example75() {
+	words := []string{"hello", "world", "go", "memory", "model"}
+	fmt.Fprintln(Output, ParallelMap(words, 2, func(w string) int { return len(w) }))
+}
+
+// A receive from a nil channel blocks forever, so a select never chooses a case on a nil
+// channel. Setting a channel variable to nil disables its case without restructuring the select
+
+// Example29 takes values from two sources in turn by disabling the source whose turn it is
+// not. Once a source is exhausted its case stays disabled and the other source is drained
+func Example29() {
+	for _, v := range alternate(source(1, 3, 5, 7, 9), source(2, 4, 6)) {
+		fmt.Fprintln(Output, v)
+	}
+}
+
+// source returns a buffered channel holding vals that is already closed
+func source(vals ...int) <-chan int {
+	ch := make(chan int, len(vals))
+	for _, v := range vals {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+// alternate receives from a and b in turn, starting with a, until both are closed
+func alternate(a, b <-chan int) []int {
+	var out []int
+	takeA := true
+	for a != nil || b != nil {
+		ca, cb := a, b
+		if takeA && a != nil {
+			cb = nil // not b's turn
+		} else if !takeA && b != nil {
+			ca = nil // not a's turn
+		}
+
+		select {
+		case v, ok := <-ca:
+			if !ok {
+				a = nil // exhausted, disable for good
+				continue
+			}
+			out = append(out, v)
+			takeA = false
+		case v, ok := <-cb:
+			if !ok {
+				b = nil
+				continue
+			}
+			out = append(out, v)
+			takeA = true
+		}
+	}
+	return out
+}
+
+// Rate limiting
+// Example6 limits parallelism: how many work functions run at the same time. A ticker limits
+// rate instead: how many operations start per unit of time, however long each one takes
+
+// Example32 handles three requests, at most one per 5 milliseconds
+func Example32() {
+	throttle(context.Background(), 5*time.Millisecond, source(1, 2, 3), func(r int) {
+		fmt.Fprintln(Output, "request", r)
+	})
+}
+
+// throttle calls handle for each request, waiting for a tick of a ticker with the given
+// interval before each one. It stops once requests is closed or ctx is done and returns the
+// number of requests handled
+func throttle(ctx context.Context, interval time.Duration, requests <-chan int, handle func(int)) int {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop() // a ticker that is never stopped keeps firing
+
+	handled := 0
+	for r := range requests {
+		select {
+		case <-ticker.C:
+			handle(r)
+			handled++
+		case <-ctx.Done():
+			return handled
+		}
+	}
+	return handled
+}
+
+// Futures
+// A future starts a computation right away and hands back a way to wait for its result.
+// The send of the result is synchronized before the receive that completes the wait
+
+// Async starts f in a new goroutine and returns a function that waits for and returns its
+// result. The result is cached, so every call after the first returns immediately. The
+// result channel is buffered so the goroutine can exit even if the result is never requested
+func Async[T any](f func() T) func() T {
+	ch := make(chan T, 1)
+	go func() { ch <- f() }()
+	return sync.OnceValue(func() T { return <-ch })
+}
+
+// Example39 starts two computations and collects both results
+func Example39() {
+	a := Async(func() int { return 6 * 7 })
+	b := Async(func() string { return "hello world" })
+	fmt.Fprintln(Output, a(), b())
+	fmt.Fprintln(Output, a(), b()) // cached, returns immediately
+}
+
+// Closing channels
+// Closing a channel that is already closed panics. When several goroutines may decide to
+// close the same channel, the close has to be made idempotent
+
+// Example43 closes a channel twice and recovers from the resulting panic
+func Example43() {
+	fmt.Fprintln(Output, "recovered:", doubleClose())
+}
+
+// Example43Safe has several goroutines race to close a channel through a sync.Once
+func Example43Safe() {
+	fmt.Fprintln(Output, "closes:", raceToClose(5))
+}
+
+// doubleClose closes a channel twice and returns the recovered panic value
+func doubleClose() (recovered any) {
+	defer func() { recovered = recover() }()
+	ch := make(chan struct{})
+	close(ch)
+	close(ch) // panics: close of closed channel
+	return nil
+}
+
+// onceCloser closes its channel at most once no matter how many times Close is called
+type onceCloser struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newOnceCloser() *onceCloser {
+	return &onceCloser{ch: make(chan struct{})}
+}
+
+// Close closes the channel the first time it is called and does nothing afterwards. It
+// reports whether this call closed the channel
+func (c *onceCloser) Close() (closed bool) {
+	c.once.Do(func() {
+		close(c.ch)
+		closed = true
+	})
+	return closed
+}
+
+// raceToClose has n goroutines all try to close the same onceCloser and returns how many of
+// them actually closed it
+func raceToClose(n int) int64 {
+	c := newOnceCloser()
+	var closes atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			if c.Close() {
+				closes.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	<-c.ch // closed, so this returns immediately
+	return closes.Load()
+}
+
+// Timeouts
+
+// ErrTimeout is returned by WithTimeout when the call does not complete in time
+var ErrTimeout = errors.New("timed out")
+
+// WithTimeout runs f in a new goroutine and returns its result, or the zero value and
+// ErrTimeout if f does not complete within d. f keeps running after a timeout, since Go has no
+// way to stop a goroutine from the outside, but its result channel is buffered so the
+// goroutine can still exit once f returns
+func WithTimeout[T any](d time.Duration, f func() T) (T, error) {
+	ch := make(chan T, 1)
+	go func() { ch <- f() }()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case v := <-ch:
+		return v, nil
+	case <-timer.C:
+		var zero T
+		return zero, ErrTimeout
+	}
+}
+
+// Example45 wraps a fast and a slow call with a 10 millisecond timeout
+func Example45() {
+	fast, err := WithTimeout(10*time.Millisecond, func() string { return "fast" })
+	fmt.Fprintf(Output, "%q %v\n", fast, err)
+	slow, err := WithTimeout(10*time.Millisecond, func() string {
+		time.Sleep(100 * time.Millisecond)
+		return "slow"
+	})
+	fmt.Fprintf(Output, "%q %v\n", slow, err)
+}
+
+// RecvTimeout receives from ch, giving up after d. It reports whether a value was received,
+// which is false for a timeout and for a closed channel
+// When the receive wins, the timer is stopped so it does not fire later. If Stop returns false
+// the timer has already fired and, before Go 1.23, its value is sitting in timer.C, where it
+// would be received by whoever next waits on a timer that is reused with Reset. The drain must
+// not block: since Go 1.23 a stopped timer never delivers a stale value, so a plain <-timer.C
+// after a false Stop would wait forever
+func RecvTimeout[T any](ch <-chan T, d time.Duration) (T, bool) {
+	timer := time.NewTimer(d)
+	select {
+	case v, ok := <-ch:
+		if !timer.Stop() {
+			select {
+			case <-timer.C: // drain the value of a timer that already fired
+			default:
+			}
+		}
+		return v, ok
+	case <-timer.C:
+		var zero T
+		return zero, false
+	}
+}
+
+// Example70 receives once from a channel that has a value ready and once from one that
+// never gets one
+func Example70() {
+	ready := make(chan string, 1)
+	ready <- "hello world"
+	v, ok := RecvTimeout(ready, 10*time.Millisecond)
+	fmt.Fprintf(Output, "%q %v\n", v, ok)
+	v, ok = RecvTimeout(make(chan string), 10*time.Millisecond)
+	fmt.Fprintf(Output, "%q %v\n", v, ok)
+}
+
+// Pipeline cancellation
+// A downstream stage that stops reading early leaves upstream stages blocked on sends
+// forever. Following https://go.dev/blog/pipelines, every stage also selects on a shared done
+// channel, and closing done, a broadcast to all stages, tears the whole pipeline down
+
+// Example50 takes the first three squares of even numbers from an endless pipeline and then
+// cancels it
+func Example50() {
+	done := make(chan struct{})
+	defer close(done)
+
+	out := transform(done, filter(done, naturals(done), isEven), square)
+	for range 3 {
+		fmt.Fprintln(Output, <-out)
+	}
+}
+
+func isEven(n int) bool { return n%2 == 0 }
+func square(n int) int  { return n * n }
+
+// naturals sends 1, 2, 3, ... until done is closed
+func naturals(done <-chan struct{}) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for n := 1; ; n++ {
+			select {
+			case out <- n:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// filter forwards the values from in for which keep returns true
+func filter(done <-chan struct{}, in <-chan int, keep func(int) bool) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for n := range in {
+			if !keep(n) {
+				continue
+			}
+			select {
+			case out <- n:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// transform forwards f applied to each value from in
+func transform(done <-chan struct{}, in <-chan int, f func(int) int) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for n := range in {
+			select {
+			case out <- f(n):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Timers in loops
+// time.After(d) creates a new Timer on every call and returns its channel. Inside a loop that
+// usually receives from another case first, each iteration abandons a timer that has not
+// fired yet. Before Go 1.23 an abandoned timer was not garbage collected until it fired, so a
+// busy loop with a long timeout piled up one live timer per iteration. Since Go 1.23 an
+// unreferenced timer can be collected right away, but every iteration still allocates a fresh
+// timer and channel. A single Timer that is Reset on each iteration does neither
+
+// Example59Leaky processes events until the channel is closed or none arrives for 10
+// milliseconds, creating a new timer for every event
+func Example59Leaky() {
+	fmt.Fprintln(Output, "processed", consumeWithAfter(source(1, 2, 3), 10*time.Millisecond), "events")
+}
+
+// Example59Fixed does the same with one reusable timer
+func Example59Fixed() {
+	fmt.Fprintln(Output, "processed", consumeWithTimer(source(1, 2, 3), 10*time.Millisecond), "events")
+}
+
+// consumeWithAfter receives events until the channel is closed or idle passes without one
+func consumeWithAfter(events <-chan int, idle time.Duration) int {
+	n := 0
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return n
+			}
+			n++
+		case <-time.After(idle): // a new timer per iteration
+			return n
+		}
+	}
+}
+
+// consumeWithTimer is consumeWithAfter with a single timer that is reset for every event
+func consumeWithTimer(events <-chan int, idle time.Duration) int {
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	n := 0
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return n
+			}
+			n++
+			// since Go 1.23 Reset also discards a value that fired but was not yet received,
+			// so there is no need to Stop and drain the channel first
+			timer.Reset(idle)
+		case <-timer.C:
+			return n
+		}
+	}
+}
+
+// Panics in goroutines
+// A panic that is not recovered in the goroutine where it happened crashes the whole program,
+// and no other goroutine can recover it. To let a parent decide what to do about a failed
+// child, the child has to recover the panic itself and hand it over, here as an error
+
+// PanicError is a panic recovered from a goroutine started by Go
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Go runs f in a new goroutine and returns a channel that receives nil once f returns, or a
+// *PanicError if f panics. The channel is buffered so the goroutine can exit even if nobody
+// receives from it
+func Go(f func()) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- &PanicError{Value: v, Stack: debug.Stack()}
+				return
+			}
+			done <- nil
+		}()
+		f()
+	}()
+	return done
+}
+
+// Example62 starts several children, one of which panics, and gathers how each one ended
+func Example62() {
+	var children []<-chan error
+	for i := range 3 {
+		children = append(children, Go(func() {
+			if i == 1 {
+				panic("hello world")
+			}
+		}))
+	}
+	for i, done := range children {
+		fmt.Fprintf(Output, "child %d: %v\n", i, <-done)
+	}
+}
+
+// Example74 contrasts the two ways a goroutine can fail. An error is an ordinary value that
+// the goroutine sends back on its result channel, and the caller decides what to do with it. A
+// panic that the goroutine does not recover unwinds only that goroutine's stack and then
+// terminates the whole process, so it is not shown running here; unrecoveredPanic is run in a
+// child process by the tests instead. Wrapping the goroutine body with a deferred recover, the
+// idiom behind the Go helper of Example62, turns the panic into an error on the result channel
+func Example74() {
+	fmt.Fprintln(Output, "error:", errorInGoroutine())
+	fmt.Fprintln(Output, "recovered:", runRecovered(func() error { panic("hello world") }))
+}
+
+// errorInGoroutine runs a goroutine that fails with an error and returns the error
+func errorInGoroutine() error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- errTaskFailed
+	}()
+	return <-errs
+}
+
+// unrecoveredPanic runs a goroutine that panics without recovering. It never returns: the
+// panic crashes the program while the caller waits
+func unrecoveredPanic() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		panic("hello world")
+	}()
+	<-done
+}
+
+// runRecovered runs body in a new goroutine and returns its error, or the panic it raised
+// converted into an error
+func runRecovered(body func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+			errs <- err
+		}()
+		err = body()
+	}()
+	return <-errs
+}
+
+// Multiple producers, single consumer
+// When several producers send on one channel, none of them can close it: a producer that
+// closes while another is still sending makes that send panic. Instead the producers report to
+// a WaitGroup and a separate closer goroutine closes the channel once Wait returns. Every
+// send happens before its producer's Done, and Done is synchronized before Wait returns, so no
+// send can come after the close and the consumer's range loop sees every value
+
+// Example66 drains three producers into a single consumer
+func Example66() {
+	received := produceConsumeMany(3, 4)
+	sum := 0
+	for _, v := range received {
+		sum += v
+	}
+	fmt.Fprintln(Output, "received", len(received), "items, sum", sum)
+}
+
+// produceConsumeMany starts producers goroutines that each send perProducer distinct values
+// on a shared channel and returns everything a single consumer received
+func produceConsumeMany(producers, perProducer int) []int {
+	c := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := range producers {
+		go func(p int) {
+			defer wg.Done()
+			for i := range perProducer {
+				c <- p*perProducer + i
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(c) // the only close, after the last send
+	}()
+
+	var received []int
+	for v := range c {
+		received = append(received, v)
+	}
+	return received
+}
+
+// Draining on shutdown
+// Closing a buffered channel does not discard the values still in its buffer: receives keep
+// returning them until the buffer is empty, and only then report the channel as closed. A
+// producer that shuts down by closing the channel therefore lets a ranging consumer finish
+// every item that was already queued. Shutting the consumer down through a separate stop
+// signal that it checks first abandons whatever is still buffered
+
+// Example87 queues five items, shuts down while the consumer is still busy, and counts how
+// many of the queued items were processed, first with a graceful and then with an abrupt
+// shutdown
+func Example87() {
+	fmt.Fprintln(Output, "graceful: processed", drainOnShutdown(5, true), "of 5")
+	fmt.Fprintln(Output, "abrupt: processed", drainOnShutdown(5, false), "of 5")
+}
+
+// drainOnShutdown fills a buffered queue with n items and then shuts down, either gracefully
+// by closing the queue or abruptly by closing a stop channel, before the consumer gets to
+// the items. It returns how many items the consumer processed before exiting
+func drainOnShutdown(n int, graceful bool) int {
+	queue := make(chan int, n)
+	stop := make(chan struct{})
+	busy := make(chan struct{}) // stands in for earlier work, closed once shutdown has begun
+	done := make(chan int)
+
+	go func() {
+		<-busy
+		processed := 0
+		if graceful {
+			for range queue { // drains the buffer, then ends because the queue is closed
+				processed++
+			}
+			done <- processed
+			return
+		}
+		for {
+			select {
+			case <-stop: // checked first, so buffered items are abandoned
+				done <- processed
+				return
+			default:
+			}
+			select {
+			case <-stop:
+				done <- processed
+				return
+			case <-queue:
+				processed++
+			}
+		}
+	}()
+
+	for i := range n {
+		queue <- i
+	}
+	if graceful {
+		close(queue) // no more items, but the queued ones stay
+	} else {
+		close(stop)
+	}
+	close(busy)
+	return <-done
+}
+
+// Actors
+// An actor is a goroutine that owns some state and is the only one to touch it. Other
+// goroutines never access the state directly: they send messages to the actor's mailbox, and
+// the actor handles them one at a time. "Do not communicate by sharing memory; share memory by
+// communicating." Since only one goroutine ever accesses the state, it needs no lock
+
+// counterMsg is a message for a counterActor
+type counterMsg interface {
+	counterMsg()
+}
+
+// incrementMsg asks the actor to add by to its count
+type incrementMsg struct {
+	by int
+}
+
+// queryMsg asks the actor to send its count on reply
+type queryMsg struct {
+	reply chan<- int
+}
+
+func (incrementMsg) counterMsg() {}
+func (queryMsg) counterMsg()     {}
+
+// counterActor is a counter owned by a goroutine of its own
+type counterActor struct {
+	mailbox chan counterMsg
+	done    chan struct{}
+}
+
+// newCounterActor starts the actor's goroutine. Stop must be called to end it
+func newCounterActor() *counterActor {
+	a := &counterActor{mailbox: make(chan counterMsg), done: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+func (a *counterActor) run() {
+	defer close(a.done)
+	count := 0 // private to this goroutine
+	for msg := range a.mailbox {
+		switch msg := msg.(type) {
+		case incrementMsg:
+			count += msg.by
+		case queryMsg:
+			msg.reply <- count
+		}
+	}
+}
+
+// Increment adds by to the count
+func (a *counterActor) Increment(by int) {
+	a.mailbox <- incrementMsg{by: by}
+}
+
+// Count returns the count after every message sent to the actor before the query has been
+// handled
+func (a *counterActor) Count() int {
+	reply := make(chan int)
+	a.mailbox <- queryMsg{reply: reply}
+	return <-reply
+}
+
+// Stop closes the mailbox and waits for the actor to exit. The actor must not be used
+// afterwards
+func (a *counterActor) Stop() {
+	close(a.mailbox)
+	<-a.done
+}
+
+// Example78 increments a counter actor three times and then asks for the count
+func Example78() {
+	a := newCounterActor()
+	defer a.Stop()
+	for i := 1; i <= 3; i++ {
+		a.Increment(i)
+	}
+	fmt.Fprintln(Output, "count:", a.Count())
+}
+
+// Debouncing
+// A burst of events often only needs to be handled once, after it has settled: saving a file
+// after the last keystroke, reloading a config after the last write to it. A debouncer
+// restarts a timer on every event and only acts when the timer gets to fire
+
+// Debounce returns a trigger function that calls f once d has passed since the last call to
+// trigger, so a burst of triggers results in a single call. f runs on the debouncer's own
+// goroutine, one call at a time; triggers made while f runs wait for it to return. stop
+// cancels a pending call, waits for a running one and ends the goroutine. Calls to trigger
+// after stop do nothing
+func Debounce(d time.Duration, f func()) (trigger func(), stop func()) {
+	triggers := make(chan struct{})
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(d)
+		timer.Stop()
+		var fire <-chan time.Time // nil, and so never ready, while no call is pending
+		for {
+			select {
+			case <-triggers:
+				timer.Reset(d) // discards a value that fired but was not received yet
+				fire = timer.C
+			case <-fire:
+				fire = nil
+				f()
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	trigger = func() {
+		select {
+		case triggers <- struct{}{}:
+		case <-done:
+		}
+	}
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+	return trigger, stop
+}
+
+// Example81 triggers a debounced function five times in a row and waits for it to settle
+func Example81() {
+	var calls atomic.Int64
+	trigger, stop := Debounce(10*time.Millisecond, func() { calls.Add(1) })
+	defer stop()
+	for range 5 {
+		trigger()
+	}
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprintln(Output, "calls:", calls.Load())
+}
+
+// Publish/subscribe
+// Closing a channel, as in Example22, broadcasts a single event to any number of goroutines.
+// Broadcasting a stream of values needs a channel per subscriber and a publisher that sends
+// every value to each of them. A publisher that blocks on a slow subscriber stalls every other
+// subscriber too, so each subscriber channel gets a buffer and a value that does not fit is
+// dropped for that subscriber, as in Example47
+
+// Message is a value published on a Hub
+type Message string
+
+// Hub broadcasts published messages to all of its subscribers
+type Hub struct {
+	mu     sync.RWMutex
+	buffer int
+	subs   []chan Message
+	closed bool
+}
+
+// NewHub returns a hub that buffers up to buffer messages for each subscriber. Messages
+// published while a subscriber's buffer is full are dropped for that subscriber
+func NewHub(buffer int) *Hub {
+	return &Hub{buffer: buffer}
+}
+
+// Subscribe returns a channel that receives every message published from now on, until the
+// hub is closed. Subscribing to a closed hub returns a closed channel
+func (h *Hub) Subscribe() <-chan Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan Message, h.buffer)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs = append(h.subs, ch)
+	return ch
+}
+
+// Publish delivers m to every subscriber that has room for it, without waiting for any of
+// them. Publishing to a closed hub does nothing
+func (h *Hub) Publish(m Message) {
+	// a read lock lets publishers run in parallel while keeping Close from closing a channel
+	// under a send
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return
+	}
+	for _, ch := range h.subs {
+		select {
+		case ch <- m:
+		default: // the subscriber is not keeping up
+		}
+	}
+}
+
+// Close closes every subscriber channel, after any messages still buffered in it. Calling
+// Close more than once is fine
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+}
+
+// Example89 publishes three messages to two subscribers, one of which subscribes late
+func Example89() {
+	h := NewHub(10)
+	early := h.Subscribe()
+	h.Publish("hello")
+	late := h.Subscribe()
+	h.Publish("memory")
+	h.Publish("model")
+	h.Close()
+
+	for _, sub := range []struct {
+		name string
+		ch   <-chan Message
+	}{{"early", early}, {"late", late}} {
+		var got []Message
+		for m := range sub.ch {
+			got = append(got, m)
+		}
+		fmt.Fprintln(Output, sub.name, got)
+	}
+}
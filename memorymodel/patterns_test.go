@@ -0,0 +1,756 @@
+package memorymodel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// leakyQueryEnv makes TestExample13LeakHelper run leakyQuery instead of skipping
+const leakyQueryEnv = "GOBLOGS_LEAKY_QUERY_HELPER"
+
+// TestExample13Leak runs leakyQuery in a child process so the goroutine it strands does not
+// stay blocked for the rest of this test binary
+func TestExample13Leak(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExample13LeakHelper$", "-test.count=1", "-test.v")
+	cmd.Env = append(os.Environ(), leakyQueryEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child process failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "--- PASS: TestExample13LeakHelper") {
+		t.Errorf("child process did not run the leak check:\n%s", out)
+	}
+}
+
+func TestExample13LeakHelper(t *testing.T) {
+	if os.Getenv(leakyQueryEnv) == "" {
+		t.Skip("only run as a child process of TestExample13Leak")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	baseline := runtime.NumGoroutine()
+	if _, err := leakyQuery(ctx); err != context.Canceled {
+		t.Fatalf("leakyQuery returned %v, want %v", err, context.Canceled)
+	}
+	time.Sleep(50 * time.Millisecond) // long enough for slowLookup to return
+	if n := runtime.NumGoroutine(); n < baseline+1 {
+		t.Errorf("%d goroutines running, want at least %d (one leaked)", n, baseline+1)
+	}
+}
+
+func TestExample13Fixed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	baseline := runtime.NumGoroutine()
+	if _, err := fixedQuery(ctx); err != context.Canceled {
+		t.Fatalf("fixedQuery returned %v, want %v", err, context.Canceled)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample14Squares(t *testing.T) {
+	var nums, want []int
+	for i := 1; i <= 20; i++ {
+		nums = append(nums, i)
+		want = append(want, i*i)
+	}
+
+	var got []int
+	for v := range squarePipeline(4, nums...) {
+		got = append(got, v)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExample16Fairness(t *testing.T) {
+	const n = 10000
+	a, b := selectCounts(n)
+	if a+b != n {
+		t.Fatalf("counted %d selects, want %d", a+b, n)
+	}
+	if a < n*3/10 || b < n*3/10 {
+		t.Errorf("a = %d, b = %d; want each above 30%% of %d", a, b, n)
+	}
+}
+
+func TestExample24Alternates(t *testing.T) {
+	const rounds = 100
+	turns := pingPong(rounds)
+	if len(turns) != 2*rounds {
+		t.Fatalf("recorded %d turns, want %d", len(turns), 2*rounds)
+	}
+	for i, turn := range turns {
+		want := "A"
+		if i%2 == 1 {
+			want = "B"
+		}
+		if turn != want {
+			t.Fatalf("turn %d = %s, want %s", i, turn, want)
+		}
+	}
+}
+
+func TestExample28WorkerPool(t *testing.T) {
+	var jobs []int
+	for i := range 50 {
+		jobs = append(jobs, i)
+	}
+
+	got := make(map[int]int)
+	for r := range workerPool(5, jobs, func(x int) int { return x * x }) {
+		if _, dup := got[r.job]; dup {
+			t.Errorf("job %d reported twice", r.job)
+		}
+		got[r.job] = r.value
+	}
+	if len(got) != 50 {
+		t.Fatalf("collected %d results, want 50", len(got))
+	}
+	for j, v := range got {
+		if v != j*j {
+			t.Errorf("job %d = %d, want %d", j, v, j*j)
+		}
+	}
+}
+
+func TestExample29Alternate(t *testing.T) {
+	got := alternate(source(1, 3, 5), source(2, 4, 6, 8, 10))
+	want := []int{1, 2, 3, 4, 5, 6, 8, 10}
+	if !slices.Equal(got, want) {
+		t.Errorf("alternate = %v, want %v", got, want)
+	}
+
+	if got := alternate(source(), source(1, 2)); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("alternate with empty a = %v, want [1 2]", got)
+	}
+}
+
+func TestExample32Rate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	requests := make(chan int)
+	go func() {
+		defer close(requests)
+		for i := 0; ; i++ {
+			select {
+			case requests <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// 200ms at one request per 20ms allows at most 10 requests
+	handled := throttle(ctx, 20*time.Millisecond, requests, func(int) {})
+	if handled < 5 || handled > 10 {
+		t.Errorf("handled %d requests, want between 5 and 10", handled)
+	}
+}
+
+func TestExample36Merge(t *testing.T) {
+	var got []int
+	for v := range Merge(source(1, 4, 7), source(2, 5, 8), source(3, 6, 9, 10)) {
+		got = append(got, v)
+	}
+	slices.Sort(got)
+	want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if !slices.Equal(got, want) {
+		t.Errorf("Merge = %v, want %v", got, want)
+	}
+}
+
+func TestExample36MergeNone(t *testing.T) {
+	if _, ok := <-Merge[int](); ok {
+		t.Error("Merge of no channels should be closed immediately")
+	}
+}
+
+func TestExample37Tee(t *testing.T) {
+	var in []int
+	for i := range 50 {
+		in = append(in, i)
+	}
+	a, b := Tee(source(in...))
+
+	gotB := make(chan []int)
+	go func() {
+		var got []int
+		for v := range b {
+			time.Sleep(100 * time.Microsecond) // a slow consumer
+			got = append(got, v)
+		}
+		gotB <- got
+	}()
+	var gotA []int
+	for v := range a {
+		gotA = append(gotA, v)
+	}
+
+	if !slices.Equal(gotA, in) {
+		t.Errorf("first output = %v, want %v", gotA, in)
+	}
+	if got := <-gotB; !slices.Equal(got, in) {
+		t.Errorf("second output = %v, want %v", got, in)
+	}
+}
+
+func TestExample39Async(t *testing.T) {
+	var calls atomic.Int64
+	get := Async(func() int {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return 42
+	})
+
+	if v := get(); v != 42 {
+		t.Errorf("first call = %d, want 42", v)
+	}
+	if v := get(); v != 42 {
+		t.Errorf("second call = %d, want 42", v)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("f ran %d times, want 1", n)
+	}
+}
+
+func TestExample39AsyncNeverAwaited(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	Async(func() int { return 1 })
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample43DoubleClosePanics(t *testing.T) {
+	r := doubleClose()
+	err, ok := r.(error)
+	if !ok || err.Error() != "close of closed channel" {
+		t.Errorf("recovered %v, want a close of closed channel panic", r)
+	}
+}
+
+func TestExample43SafeClose(t *testing.T) {
+	for range 20 {
+		if n := raceToClose(100); n != 1 {
+			t.Fatalf("channel closed %d times, want 1", n)
+		}
+	}
+}
+
+func TestExample45Fast(t *testing.T) {
+	v, err := WithTimeout(time.Second, func() int { return 42 })
+	if v != 42 || err != nil {
+		t.Errorf("WithTimeout = %d, %v; want 42, nil", v, err)
+	}
+}
+
+func TestExample45Timeout(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	v, err := WithTimeout(10*time.Millisecond, func() int {
+		time.Sleep(50 * time.Millisecond)
+		return 42
+	})
+	if v != 0 || err != ErrTimeout {
+		t.Errorf("WithTimeout = %d, %v; want 0, %v", v, err, ErrTimeout)
+	}
+	AssertNoLeak(t, baseline) // the goroutine exits once f returns
+}
+
+func TestExample47DropsWhenBusy(t *testing.T) {
+	sent, dropped, received, producing := dropWhenBusy(1000, 4, time.Millisecond)
+	if sent+dropped != 1000 {
+		t.Errorf("sent %d + dropped %d, want 1000 in total", sent, dropped)
+	}
+	if dropped == 0 {
+		t.Error("no values were dropped for a slow consumer")
+	}
+	if received != sent {
+		t.Errorf("consumer received %d of %d sent values", received, sent)
+	}
+	// blocking on the consumer even a few times would take a millisecond each
+	if producing > 500*time.Millisecond {
+		t.Errorf("producer took %v, want it never to block", producing)
+	}
+}
+
+func TestExample50Cancellation(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	done := make(chan struct{})
+	out := transform(done, filter(done, naturals(done), isEven), square)
+
+	var got []int
+	for range 5 {
+		got = append(got, <-out)
+	}
+	close(done)
+
+	if want := []int{4, 16, 36, 64, 100}; !slices.Equal(got, want) {
+		t.Errorf("pipeline produced %v, want %v", got, want)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample52Generator(t *testing.T) {
+	in := []int{5, 3, 8, 1}
+	ch := Generator(in...)
+
+	var got []int
+	for range in {
+		got = append(got, <-ch)
+	}
+	if !slices.Equal(got, in) {
+		t.Errorf("Generator produced %v, want %v", got, in)
+	}
+	if v, ok := <-ch; ok {
+		t.Errorf("Generator sent extra value %d, want the channel closed", v)
+	}
+}
+
+func TestExample59Allocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector changes allocation counts")
+	}
+	const events = 100
+	allocs := func(consume func(<-chan int, time.Duration) int) float64 {
+		ch := make(chan int, events)
+		return testing.AllocsPerRun(10, func() {
+			for i := range events {
+				ch <- i
+			}
+			if n := consume(ch, time.Millisecond); n != events {
+				t.Fatalf("consumed %d events, want %d", n, events)
+			}
+		})
+	}
+
+	leaky, fixed := allocs(consumeWithAfter), allocs(consumeWithTimer)
+	t.Logf("allocations per run: time.After %.0f, reused timer %.0f", leaky, fixed)
+	if leaky < events {
+		t.Errorf("time.After allocated %.0f times for %d events, want at least one per event", leaky, events)
+	}
+	if fixed > 10 {
+		t.Errorf("reused timer allocated %.0f times, want a constant handful", fixed)
+	}
+}
+
+func BenchmarkTimerAfter(b *testing.B) {
+	ch := make(chan int, 1)
+	for range b.N {
+		ch <- 0
+		select {
+		case <-ch:
+		case <-time.After(time.Hour):
+		}
+	}
+}
+
+func BenchmarkTimerReset(b *testing.B) {
+	ch := make(chan int, 1)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for range b.N {
+		ch <- 0
+		select {
+		case <-ch:
+			timer.Reset(time.Hour)
+		case <-timer.C:
+		}
+	}
+}
+
+func TestExample62GoReturnsNil(t *testing.T) {
+	ran := false
+	if err := <-Go(func() { ran = true }); err != nil || !ran {
+		t.Errorf("Go returned %v after running f: %v, want nil after running it", err, ran)
+	}
+}
+
+func TestExample62GoRecoversPanic(t *testing.T) {
+	err := <-Go(func() { panic("boom") })
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Go returned %v, want a *PanicError", err)
+	}
+	if pe.Value != "boom" {
+		t.Errorf("recovered %v, want %q", pe.Value, "boom")
+	}
+	if !strings.Contains(string(pe.Stack), "TestExample62GoRecoversPanic") {
+		t.Errorf("stack does not show where the panic happened:\n%s", pe.Stack)
+	}
+}
+
+func TestExample62GoNeverReceived(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	Go(func() { panic("boom") })
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample66ManyProducers(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	received := produceConsumeMany(5, 20)
+	if len(received) != 100 {
+		t.Fatalf("consumer received %d items, want 100", len(received))
+	}
+	slices.Sort(received)
+	for i, v := range received {
+		if v != i {
+			t.Fatalf("received %v, want every value in [0, 100) exactly once", received)
+		}
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample70RecvTimeout(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	ch := make(chan int)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		ch <- 42
+	}()
+	if v, ok := RecvTimeout(ch, time.Second); v != 42 || !ok {
+		t.Errorf("RecvTimeout = %d, %v, want 42, true", v, ok)
+	}
+
+	start := time.Now()
+	if v, ok := RecvTimeout(make(chan int), 10*time.Millisecond); v != 0 || ok {
+		t.Errorf("RecvTimeout = %d, %v, want 0, false", v, ok)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v, want about 10ms", elapsed)
+	}
+
+	closed := make(chan int)
+	close(closed)
+	if v, ok := RecvTimeout(closed, time.Second); v != 0 || ok {
+		t.Errorf("RecvTimeout on a closed channel = %d, %v, want 0, false", v, ok)
+	}
+
+	// timers do not run goroutines of their own, and neither the sender nor anything else
+	// started here may outlive the calls
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample70RecvTimeoutRace(t *testing.T) {
+	// deadlines that expire while the value is being received exercise the false Stop path,
+	// which must never block
+	for range 200 {
+		ch := make(chan int, 1)
+		ch <- 1
+		done := make(chan struct{})
+		go func() {
+			RecvTimeout(ch, time.Nanosecond)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("RecvTimeout blocked after the timer fired")
+		}
+	}
+}
+
+// unrecoveredPanicEnv makes TestExample74CrashHelper panic instead of skipping
+const unrecoveredPanicEnv = "GOBLOGS_UNRECOVERED_PANIC_HELPER"
+
+func TestExample74ErrorReturned(t *testing.T) {
+	if err := errorInGoroutine(); err != errTaskFailed {
+		t.Errorf("errorInGoroutine() = %v, want %v", err, errTaskFailed)
+	}
+}
+
+func TestExample74RecoveredPanic(t *testing.T) {
+	err := runRecovered(func() error { panic("boom") })
+	if err == nil || err.Error() != "panic: boom" {
+		t.Errorf("runRecovered returned %v, want the panic as an error", err)
+	}
+	if err := runRecovered(func() error { return errTaskFailed }); err != errTaskFailed {
+		t.Errorf("runRecovered returned %v, want %v", err, errTaskFailed)
+	}
+	if err := runRecovered(func() error { return nil }); err != nil {
+		t.Errorf("runRecovered returned %v, want nil", err)
+	}
+}
+
+// TestExample74UnrecoveredPanicCrashes runs unrecoveredPanic in a child process, since the
+// panic takes down whatever process it happens in
+func TestExample74UnrecoveredPanicCrashes(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExample74CrashHelper$", "-test.count=1")
+	cmd.Env = append(os.Environ(), unrecoveredPanicEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("child process survived an unrecovered panic:\n%s", out)
+	}
+	if !strings.Contains(string(out), "panic: hello world") {
+		t.Errorf("child process did not report the panic:\n%s", out)
+	}
+}
+
+func TestExample74CrashHelper(t *testing.T) {
+	if os.Getenv(unrecoveredPanicEnv) == "" {
+		t.Skip("only run as a child process of TestExample74UnrecoveredPanicCrashes")
+	}
+	unrecoveredPanic()
+}
+
+func TestExample75ParallelMap(t *testing.T) {
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+	f := func(x int) string { return strconv.Itoa(x * x) }
+
+	for _, concurrency := range []int{1, 3, 8, 99} {
+		var inFlight, peak atomic.Int64
+		out := ParallelMap(in, concurrency, func(x int) string {
+			raiseMax(&peak, inFlight.Add(1))
+			defer inFlight.Add(-1)
+			time.Sleep(time.Duration(x%3) * 100 * time.Microsecond) // finish out of order
+			return f(x)
+		})
+		if len(out) != len(in) {
+			t.Fatalf("concurrency %d: got %d results, want %d", concurrency, len(out), len(in))
+		}
+		for i := range in {
+			if out[i] != f(in[i]) {
+				t.Errorf("concurrency %d: output[%d] = %q, want %q", concurrency, i, out[i], f(in[i]))
+			}
+		}
+		if n := peak.Load(); n > int64(concurrency) {
+			t.Errorf("concurrency %d: %d calls ran at once", concurrency, n)
+		}
+	}
+}
+
+func TestExample75ParallelMapEmpty(t *testing.T) {
+	if out := ParallelMap([]int(nil), 4, func(x int) int { return x }); len(out) != 0 {
+		t.Errorf("ParallelMap(nil) = %v, want an empty slice", out)
+	}
+}
+
+func TestExample78Actor(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	a := newCounterActor()
+
+	for range 5 {
+		a.Increment(2)
+	}
+	if n := a.Count(); n != 10 {
+		t.Errorf("count after 5 increments by 2 = %d, want 10", n)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for range 10 {
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				a.Increment(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := a.Count(); n != 1010 {
+		t.Errorf("count after concurrent increments = %d, want 1010", n)
+	}
+
+	a.Stop()
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample81Coalesces(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	calls := make(chan time.Time, 10)
+	trigger, stop := Debounce(20*time.Millisecond, func() { calls <- time.Now() })
+
+	var last time.Time
+	for range 50 {
+		trigger()
+		last = time.Now()
+		time.Sleep(200 * time.Microsecond) // well within the debounce interval
+	}
+	select {
+	case at := <-calls:
+		if at.Sub(last) < 20*time.Millisecond {
+			t.Errorf("f ran %v after the last trigger, want at least 20ms", at.Sub(last))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("f never ran")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(calls); n != 0 {
+		t.Errorf("f ran %d more times, want exactly once", n)
+	}
+
+	stop()
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample81StopCancelsPending(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	var calls atomic.Int64
+	trigger, stop := Debounce(20*time.Millisecond, func() { calls.Add(1) })
+	trigger()
+	stop()
+	trigger() // no-op after stop
+	stop()    // stopping twice is fine
+
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 0 {
+		t.Errorf("f ran %d times after stop, want 0", n)
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample85HighFirst(t *testing.T) {
+	const n = 100
+	high, low := make(chan int, n), make(chan int, n)
+	for i := range n {
+		low <- -i - 1
+		high <- i
+	}
+	for i := range 2 * n {
+		v := priorityRecv(high, low)
+		if i < n && v < 0 {
+			t.Fatalf("receive %d took low priority item %d while high priority items were waiting", i, v)
+		}
+		if i >= n && v >= 0 {
+			t.Fatalf("receive %d took high priority item %d, want the high queue drained", i, v)
+		}
+	}
+}
+
+func TestExample85FallsBackToLow(t *testing.T) {
+	high, low := make(chan string), make(chan string, 1)
+	low <- "low"
+	if v := priorityRecv(high, low); v != "low" {
+		t.Errorf("priorityRecv = %q, want %q", v, "low")
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		high <- "high"
+	}()
+	if v := priorityRecv(high, low); v != "high" {
+		t.Errorf("priorityRecv = %q, want it to block until %q arrives", v, "high")
+	}
+}
+
+func TestExample86AckOrdersWork(t *testing.T) {
+	want := []string{"producer handing off", "consumer received", "consumer finished", "producer resumed"}
+	for range 100 {
+		contents, events := handoffWithAck()
+		if !slices.Equal(events, want) {
+			t.Fatalf("recorded %v, want %v", events, want)
+		}
+		if contents != "hello world" {
+			t.Fatalf("producer read %q after the acknowledgment, want %q", contents, "hello world")
+		}
+	}
+}
+
+func TestExample87GracefulDrain(t *testing.T) {
+	for _, n := range []int{1, 10, 100} {
+		if got := drainOnShutdown(n, true); got != n {
+			t.Errorf("graceful shutdown processed %d of %d buffered items", got, n)
+		}
+	}
+}
+
+func TestExample87AbruptDrops(t *testing.T) {
+	if got := drainOnShutdown(10, false); got != 0 {
+		t.Errorf("abrupt shutdown processed %d buffered items, want them dropped", got)
+	}
+}
+
+func TestExample89HubDelivers(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	h := NewHub(100)
+
+	const subscribers, before, after = 4, 10, 20
+	received := make([][]Message, subscribers)
+	var wg sync.WaitGroup
+	subscribe := func(i int) {
+		ch := h.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range ch { // ends when Close closes the channel
+				received[i] = append(received[i], m)
+			}
+		}()
+	}
+
+	subscribe(0)
+	subscribe(1)
+	for i := range before {
+		h.Publish(Message(strconv.Itoa(i)))
+	}
+	subscribe(2)
+	subscribe(3)
+	for i := range after {
+		h.Publish(Message(strconv.Itoa(before + i)))
+	}
+	h.Close()
+	h.Close()
+	if !completesWithin(time.Second, wg.Wait) {
+		t.Fatal("Close did not end every subscriber's range loop")
+	}
+
+	for i, got := range received {
+		first := 0
+		if i >= 2 {
+			first = before // subscribed after the first messages were published
+		}
+		var want []Message
+		for j := first; j < before+after; j++ {
+			want = append(want, Message(strconv.Itoa(j)))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("subscriber %d received %v, want %v", i, got, want)
+		}
+	}
+	AssertNoLeak(t, baseline)
+}
+
+func TestExample89SlowSubscriber(t *testing.T) {
+	h := NewHub(1)
+	slow := h.Subscribe() // never read until the end
+	fast := h.Subscribe()
+	var got []Message
+	for i := range 5 {
+		if !completesWithin(time.Second, func() { h.Publish(Message(strconv.Itoa(i))) }) {
+			t.Fatal("Publish blocked on a subscriber with a full buffer")
+		}
+		got = append(got, <-fast)
+	}
+	h.Close()
+
+	if want := []Message{"0", "1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("fast subscriber received %v, want %v", got, want)
+	}
+	var slowGot []Message
+	for m := range slow {
+		slowGot = append(slowGot, m)
+	}
+	if want := []Message{"0"}; !slices.Equal(slowGot, want) {
+		t.Errorf("slow subscriber received %v, want only what fit its buffer %v", slowGot, want)
+	}
+	if _, ok := <-h.Subscribe(); ok {
+		t.Error("subscribing to a closed hub returned an open channel")
+	}
+}
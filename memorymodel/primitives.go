@@ -0,0 +1,1288 @@
+package memorymodel
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+)
+
+// Examples in this file build on the synchronization primitives of the sync and sync/atomic
+// packages. Every Unlock, Signal or atomic store used here carries the same happens-before
+// edges described in memorymodel.go
+
+// sync.Cond
+// A Cond lets goroutines wait for a condition protected by a Locker. Wait atomically unlocks
+// the Locker and suspends the goroutine; the Locker is locked again before Wait returns
+
+// Example11 passes items through a bounded buffer from a producer to a consumer. The consumer
+// receives every item in the order it was produced
+func Example11() {
+	for _, v := range produceConsume(5, 2) {
+		fmt.Fprintln(Output, v)
+	}
+}
+
+// boundedBuffer is a FIFO of at most capacity items
+type boundedBuffer struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []int
+	capacity int
+}
+
+func newBoundedBuffer(capacity int) *boundedBuffer {
+	b := &boundedBuffer{capacity: capacity}
+	b.notEmpty = sync.NewCond(&b.mu)
+	b.notFull = sync.NewCond(&b.mu)
+	return b
+}
+
+// Put appends v, waiting while the buffer is full
+func (b *boundedBuffer) Put(v int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// the predicate is rechecked in a loop because Wait can return without the condition
+	// holding, e.g. when another goroutine got to the buffer first
+	for len(b.items) == b.capacity {
+		b.notFull.Wait()
+	}
+	b.items = append(b.items, v)
+	b.notEmpty.Signal()
+}
+
+// Get removes and returns the oldest item, waiting while the buffer is empty
+func (b *boundedBuffer) Get() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.items) == 0 {
+		b.notEmpty.Wait()
+	}
+	v := b.items[0]
+	b.items = b.items[1:]
+	b.notFull.Signal()
+	return v
+}
+
+// produceConsume sends 1..n through a bounded buffer of the given capacity and returns the
+// items in the order the consumer received them
+func produceConsume(n, capacity int) []int {
+	b := newBoundedBuffer(capacity)
+	go func() {
+		for i := 1; i <= n; i++ {
+			b.Put(i)
+		}
+	}()
+
+	got := make([]int, 0, n)
+	for range n {
+		got = append(got, b.Get())
+	}
+	return got
+}
+
+// atomic.Value
+// A Value provides an atomic load and store of a consistently typed value. A Load that
+// observes a Store is synchronized after it, so everything written before the Store,
+// including the fields of the stored struct, is visible to the loader
+
+// Example18 hot-reloads a configuration while readers keep consulting it. Each reload
+// publishes a brand new *Config, so readers see either the old or the new config in full and
+// never a mix of the two
+func Example18() {
+	torn, final := hotReload(4, 3, time.Millisecond)
+	fmt.Fprintln(Output, "torn reads:", torn)
+	fmt.Fprintln(Output, "final config:", final.Name)
+}
+
+// newVersionedConfig returns a config whose Name is derived from its Version, so a reader can
+// tell whether the two fields belong together
+func newVersionedConfig(version int) *Config {
+	return &Config{Name: fmt.Sprintf("v%d", version), Version: version}
+}
+
+// consistent reports whether c was fully written by newVersionedConfig
+func consistent(c *Config) bool {
+	return c.Name == fmt.Sprintf("v%d", c.Version)
+}
+
+// hotReload runs a writer goroutine that stores a new config every interval, reloads times,
+// while readers goroutines load the current one until the writer has stopped. It returns the
+// number of inconsistent configs the readers saw and the final config
+func hotReload(readers, reloads int, every time.Duration) (torn int64, final *Config) {
+	var current atomic.Value
+	current.Store(newVersionedConfig(0))
+
+	var stop atomic.Bool
+	var tornReads atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	for range readers {
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if c := current.Load().(*Config); !consistent(c) {
+					tornReads.Add(1)
+				}
+				runtime.Gosched() // let the writer run on its ticks instead of spinning it out
+			}
+		}()
+	}
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for v := 1; v <= reloads; v++ {
+			<-ticker.C
+			current.Store(newVersionedConfig(v)) // never mutate a config that was already stored
+		}
+	}()
+
+	<-written
+	stop.Store(true)
+	wg.Wait()
+	return tornReads.Load(), current.Load().(*Config)
+}
+
+// Every Store into an atomic.Value must use the same concrete type as the first one, and a nil
+// Store is not allowed either; both panic. Storing a *Config and later a Config, or a
+// different type behind the same interface, is an easy mistake when the stored type is an
+// interface. Always storing the same pointer type, here *Config, avoids it
+func Example88() {
+	fmt.Fprintln(Output, "recovered:", storeInconsistent())
+	fmt.Fprintf(Output, "after concurrent stores: %T\n", consistentStores(4))
+}
+
+// storeInconsistent stores a *Config and then a string in the same atomic.Value and returns
+// the recovered panic value
+func storeInconsistent() (recovered any) {
+	defer func() { recovered = recover() }()
+	var v atomic.Value
+	v.Store(newVersionedConfig(1))
+	v.Store("v2") // panics: a string is not a *Config
+	return nil
+}
+
+// consistentStores has goroutines goroutines store a *Config each into one atomic.Value and
+// returns the config that was stored last
+func consistentStores(goroutines int) *Config {
+	var v atomic.Value
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int) {
+			defer wg.Done()
+			v.Store(newVersionedConfig(g)) // always a *Config
+		}(g)
+	}
+	wg.Wait()
+	return v.Load().(*Config)
+}
+
+// Read-copy-update
+// atomic.Pointer does the same as atomic.Value with a type-safe API. Readers Load the current
+// pointer and use it without any lock. A writer never changes the config a reader may be
+// holding: it copies it, updates the copy and publishes the copy with a single pointer swap.
+// Mutating the shared config in place instead would race with every reader and could let a
+// reader see the new Version with the old Name. The old config stays valid for as long as a
+// reader holds it, and the garbage collector frees it once nobody does
+
+// Example77 updates a config in read-copy-update style while readers keep loading it
+func Example77() {
+	torn, final := rcuReload(4, 3)
+	fmt.Fprintln(Output, "torn reads:", torn)
+	fmt.Fprintln(Output, "final config:", final.Name, final.Version)
+}
+
+// rcuUpdate replaces the config in p with a modified copy. The CompareAndSwap only succeeds
+// if no other writer published a config since the Load, otherwise the update is retried on
+// the newer config so that concurrent updates are never lost
+func rcuUpdate(p *atomic.Pointer[Config], modify func(c *Config)) {
+	for {
+		old := p.Load()
+		next := *old // copy, never modify old
+		modify(&next)
+		if p.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+// rcuReload is hotReload with the config in an atomic.Pointer, updated with rcuUpdate
+func rcuReload(readers, updates int) (torn int64, final *Config) {
+	var current atomic.Pointer[Config]
+	current.Store(newVersionedConfig(0))
+
+	var stop atomic.Bool
+	var tornReads atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	for range readers {
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				if !consistent(current.Load()) {
+					tornReads.Add(1)
+				}
+			}
+		}()
+	}
+
+	for range updates {
+		rcuUpdate(&current, func(c *Config) {
+			c.Version++
+			c.Name = fmt.Sprintf("v%d", c.Version)
+		})
+	}
+	stop.Store(true)
+	wg.Wait()
+	return tornReads.Load(), current.Load()
+}
+
+// Weighted semaphores
+// Example6 limits the number of concurrent work functions with a buffered channel, where
+// every slot has the same cost. semaphore.Weighted limits the total weight of the work in
+// flight instead, so an expensive task can take several units of the budget
+
+// Example20 runs tasks costing 1 to 3 units each against a budget of 5 units. Acquire
+// blocks until enough of the budget has been released by finished tasks
+func Example20() {
+	weights := []int64{1, 2, 3, 1, 3, 2, 2, 1}
+	peak, err := runWeighted(context.Background(), 5, weights, func() { time.Sleep(time.Millisecond) })
+	fmt.Fprintln(Output, "completed", len(weights), "tasks, error:", err)
+	fmt.Fprintln(Output, "budget respected:", peak <= 5)
+}
+
+// runWeighted runs work once for each entry of weights, holding that much of budget while it
+// runs, and returns the highest total weight that was ever in flight
+func runWeighted(ctx context.Context, budget int64, weights []int64, work func()) (peak int64, err error) {
+	sem := semaphore.NewWeighted(budget)
+	var inFlight, maxInFlight atomic.Int64
+	var wg sync.WaitGroup
+
+	for _, w := range weights {
+		if err := sem.Acquire(ctx, w); err != nil {
+			wg.Wait()
+			return maxInFlight.Load(), err
+		}
+		wg.Add(1)
+		go func(w int64) {
+			defer wg.Done()
+			defer sem.Release(w)
+
+			raiseMax(&maxInFlight, inFlight.Add(w))
+			work()
+			inFlight.Add(-w) // before Release, so the gauge never counts a released slot
+		}(w)
+	}
+	wg.Wait()
+	return maxInFlight.Load(), nil
+}
+
+// raiseMax atomically raises peak to n if n is larger
+func raiseMax(peak *atomic.Int64, n int64) {
+	for {
+		m := peak.Load()
+		if n <= m || peak.CompareAndSwap(m, n) {
+			return
+		}
+	}
+}
+
+// Compare-and-swap
+// A successful CompareAndSwap is both a load and a store. A CAS that observes the Store in
+// Unlock is synchronized after it, so the lock hands over everything written while it was held
+
+// Example23 protects a counter shared by four goroutines with a spinlock built on
+// CompareAndSwap
+func Example23() {
+	fmt.Fprintln(Output, lockedCount(&spinLock{}, 4, 1000))
+}
+
+// spinLock is a minimal mutual exclusion lock that busy-waits instead of parking the goroutine
+type spinLock struct {
+	flag atomic.Int32
+}
+
+// Lock spins until it manages to flip the flag from 0 (unlocked) to 1 (locked)
+func (l *spinLock) Lock() {
+	for !l.flag.CompareAndSwap(0, 1) {
+		runtime.Gosched() // give the holder a chance to run and release the lock
+	}
+}
+
+// Unlock releases the lock. The store is observed by the CAS of the next Lock
+func (l *spinLock) Unlock() {
+	l.flag.Store(0)
+}
+
+// lockedCount has each of goroutines increment a plain int n times under l
+func lockedCount(l sync.Locker, goroutines, n int) int {
+	var count int
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for range goroutines {
+		go func() {
+			defer wg.Done()
+			for range n {
+				l.Lock()
+				count++
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return count
+}
+
+// sync.Map
+// A sync.Map is safe for concurrent use without additional locking. A Store is synchronized
+// before any Load that observes it. It is optimized for two cases: keys that are written once
+// and read many times, and goroutines working on disjoint sets of keys. For anything else a
+// plain map guarded by a sync.Mutex or sync.RWMutex is usually simpler and just as fast, and
+// keeps the type safety that sync.Map gives up
+
+// Example26 has writers store distinct keys while readers look them up, then tallies the
+// entries with Range once all writers are done
+func Example26() {
+	m, _ := populateSyncMap(4, 20)
+	fmt.Fprintln(Output, "entries:", countEntries(m))
+}
+
+// populateSyncMap stores keys 0..keys-1 from writers goroutines, each writing a disjoint
+// share, while the same number of readers look every key up once. It returns the map and the
+// number of lookups that found a value
+func populateSyncMap(writers, keys int) (*sync.Map, int64) {
+	var m sync.Map
+	var hits atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2 * writers)
+	for w := range writers {
+		go func(w int) {
+			defer wg.Done()
+			for k := w; k < keys; k += writers {
+				m.Store(k, k*k)
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				if _, ok := m.Load(k); ok { // may or may not have been stored yet
+					hits.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return &m, hits.Load()
+}
+
+// countEntries counts the entries of m with Range
+func countEntries(m *sync.Map) int {
+	n := 0
+	m.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// sync.Pool
+// A Pool caches allocated but unused objects for later reuse. A call to Put(x) is
+// synchronized before a Get returning that same x. The pool gives no other guarantees: any
+// object may be dropped at any time, e.g. during garbage collection, and an object that Get
+// returns may have been used before, so it must be reset before use
+
+// bufferPool holds scratch buffers shared by all goroutines
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Example27 renders greetings concurrently using scratch buffers from a sync.Pool
+func Example27() {
+	for _, s := range renderAll(3) {
+		fmt.Fprintln(Output, s)
+	}
+}
+
+// render formats a greeting for id using a pooled scratch buffer
+func render(id int) string {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset() // the buffer may still hold whatever a previous user wrote
+	defer bufferPool.Put(buf)
+
+	fmt.Fprintf(buf, "hello %d", id)
+	return buf.String() // String copies, so the result does not alias the pooled buffer
+}
+
+// renderAll renders greetings for ids 0..n-1, each in its own goroutine
+func renderAll(n int) []string {
+	out := make([]string, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			out[i] = render(i)
+		}(i)
+	}
+	wg.Wait()
+	return out
+}
+
+// TryLock
+// Since Go 1.18 Mutex.TryLock attempts to lock without blocking and reports whether it
+// succeeded. A failed TryLock does not establish any happens-before relationship.
+// Correct uses of TryLock are rare; needing it is often a sign of a deeper problem in the
+// design of the locking strategy
+
+// Example30 tries to take a lock held by another goroutine, takes an alternate path instead
+// of blocking, and then succeeds once the lock has been released
+func Example30() {
+	whileHeld, afterRelease := tryLockContended()
+	if !whileHeld {
+		fmt.Fprintln(Output, "lock busy, doing something else")
+	}
+	if afterRelease {
+		fmt.Fprintln(Output, "lock acquired")
+	}
+}
+
+// tryLockContended reports the result of TryLock while another goroutine holds the lock and
+// after it has released it
+func tryLockContended() (whileHeld, afterRelease bool) {
+	var l sync.Mutex
+	held := make(chan struct{})
+	release := make(chan struct{})
+	released := make(chan struct{})
+
+	go func() {
+		l.Lock()
+		close(held)
+		<-release
+		l.Unlock()
+		close(released)
+	}()
+
+	<-held
+	whileHeld = l.TryLock()
+	close(release)
+	<-released
+	afterRelease = l.TryLock()
+	if afterRelease {
+		l.Unlock()
+	}
+	return whileHeld, afterRelease
+}
+
+// RWMutex has TryLock and TryRLock too. TryLock fails while any reader or writer holds the
+// lock, TryRLock only while a writer holds it or is waiting for it. A legitimate use is
+// opportunistic work, such as refreshing a cache only if nobody else is using it right now,
+// where skipping the work is always an acceptable outcome
+func Example51() {
+	var l sync.RWMutex
+
+	l.RLock()
+	fmt.Fprintln(Output, "TryLock while read-locked:", l.TryLock())
+	fmt.Fprintln(Output, "TryRLock while read-locked:", tryRLockAndRelease(&l))
+	l.RUnlock()
+
+	l.Lock()
+	fmt.Fprintln(Output, "TryRLock while write-locked:", tryRLockAndRelease(&l))
+	l.Unlock()
+
+	ok := l.TryLock()
+	fmt.Fprintln(Output, "TryLock when free:", ok)
+	if ok {
+		l.Unlock()
+	}
+}
+
+// tryRLockAndRelease attempts to read-lock l, releasing it again on success
+func tryRLockAndRelease(l *sync.RWMutex) bool {
+	if !l.TryRLock() {
+		return false
+	}
+	l.RUnlock()
+	return true
+}
+
+// tryLockWhileReading reports the result of TryLock while another goroutine holds a read lock
+// and after it has released it
+func tryLockWhileReading() (whileReading, afterRelease bool) {
+	var l sync.RWMutex
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	released := make(chan struct{})
+
+	go func() {
+		l.RLock()
+		close(reading)
+		<-release
+		l.RUnlock()
+		close(released)
+	}()
+
+	<-reading
+	whileReading = l.TryLock()
+	close(release)
+	<-released
+	afterRelease = l.TryLock()
+	if afterRelease {
+		l.Unlock()
+	}
+	return whileReading, afterRelease
+}
+
+// Lock-free stack
+// A Treiber stack pushes and pops by swapping the head pointer with CompareAndSwap. A node is
+// fully initialized before the CAS that publishes it, and a successful CAS is synchronized
+// before any Load that observes the new head, so a popper always sees a complete node.
+// Go's garbage collector never reuses a node while any goroutine still references it, which
+// rules out the ABA problem that plagues this algorithm in languages with manual memory
+// management
+
+// Example38 pushes values onto a lock-free stack from several goroutines and then pops them
+func Example38() {
+	var s treiberStack
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 1; i <= 3; i++ {
+		go func(i int) {
+			defer wg.Done()
+			s.Push(i)
+		}(i)
+	}
+	wg.Wait()
+
+	sum := 0
+	for v, ok := s.Pop(); ok; v, ok = s.Pop() {
+		sum += v
+	}
+	fmt.Fprintln(Output, sum)
+}
+
+type node struct {
+	value int
+	next  *node
+}
+
+// treiberStack is a lock-free LIFO stack of ints
+type treiberStack struct {
+	head atomic.Pointer[node]
+}
+
+// Push adds v to the top of the stack
+func (s *treiberStack) Push(v int) {
+	n := &node{value: v}
+	for {
+		n.next = s.head.Load()
+		if s.head.CompareAndSwap(n.next, n) { // publishes n
+			return
+		}
+	}
+}
+
+// Pop removes and returns the value at the top of the stack. It reports false if the stack
+// was empty
+func (s *treiberStack) Pop() (int, bool) {
+	for {
+		top := s.head.Load()
+		if top == nil {
+			return 0, false
+		}
+		if s.head.CompareAndSwap(top, top.next) {
+			return top.value, true
+		}
+	}
+}
+
+// Barriers
+// A barrier makes a group of goroutines wait until all of them have arrived. Every arrival is
+// synchronized before every goroutine leaves the barrier, so work done before the barrier is
+// visible to all goroutines after it
+
+// Example40 has three goroutines finish phase 1 before any of them starts phase 2
+func Example40() {
+	events := runPhases(3)
+	fmt.Fprintln(Output, "phase 1 finished before phase 2 started:", phasesOrdered(events))
+}
+
+// barrier is a reusable barrier for a fixed number of goroutines
+type barrier struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	parties    int
+	arrived    int
+	generation int
+}
+
+func newBarrier(parties int) *barrier {
+	b := &barrier{parties: parties}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Wait blocks until all parties have called Wait, then releases them all. The barrier can be
+// reused for the next phase right away
+func (b *barrier) Wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	gen := b.generation
+	b.arrived++
+	if b.arrived == b.parties {
+		// the last to arrive starts a new generation and wakes everyone else
+		b.generation++
+		b.arrived = 0
+		b.cond.Broadcast()
+		return
+	}
+	// waiting for the generation to change rather than for arrived to reach zero keeps early
+	// arrivals of the next phase from confusing goroutines still leaving this one
+	for gen == b.generation {
+		b.cond.Wait()
+	}
+}
+
+// runPhases runs two phases of work in n goroutines separated by a barrier and returns the
+// recorded events
+func runPhases(n int) []string {
+	var rec Recorder
+	b := newBarrier(n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			rec.Event(fmt.Sprintf("phase1 %d", i))
+			b.Wait()
+			rec.Event(fmt.Sprintf("phase2 %d", i))
+			b.Wait() // reuse the barrier for the end of phase 2
+		}(i)
+	}
+	wg.Wait()
+	return rec.Events()
+}
+
+// phasesOrdered reports whether every phase1 event comes before every phase2 event
+func phasesOrdered(events []string) bool {
+	inPhase2 := false
+	for _, e := range events {
+		switch {
+		case strings.HasPrefix(e, "phase2"):
+			inPhase2 = true
+		case inPhase2:
+			return false
+		}
+	}
+	return true
+}
+
+// Unbounded queue
+// Without a capacity producers never wait, so a single Cond is enough to wake the consumer.
+// Close must also wake it, otherwise a consumer waiting on an empty queue would wait forever
+
+// Example42 passes items from two producers to a consumer through an unbounded queue and
+// closes the queue once both producers are done
+func Example42() {
+	q := newUnboundedQueue()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for p := range 2 {
+		go func(p int) {
+			defer wg.Done()
+			for i := range 3 {
+				q.Put(p*10 + i)
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		q.Close()
+	}()
+
+	sum := 0
+	for v, ok := q.Get(); ok; v, ok = q.Get() {
+		sum += v
+	}
+	fmt.Fprintln(Output, sum)
+}
+
+// unboundedQueue is a FIFO queue of ints with no capacity limit
+type unboundedQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []int
+	closed bool
+}
+
+func newUnboundedQueue() *unboundedQueue {
+	q := &unboundedQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Put appends v to the queue. It reports false if the queue has been closed
+func (q *unboundedQueue) Put(v int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, v)
+	q.cond.Signal()
+	return true
+}
+
+// Get removes and returns the oldest item, waiting while the queue is empty. It reports false
+// once the queue is closed and every item has been taken
+func (q *unboundedQueue) Get() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return 0, false
+	}
+	v := q.items[0]
+	q.items = q.items[1:]
+	return v, true
+}
+
+// Close stops the queue from accepting items and wakes every waiting consumer
+func (q *unboundedQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// sync.WaitGroup
+// A call to Done is synchronized before the return of any Wait call that it unblocks. This
+// only helps if every Add happens before Wait: an Add with a positive delta that starts when
+// the counter is zero must happen before the Wait
+
+// Example46Wrong calls wg.Add inside the goroutines. Wait can run before any goroutine has
+// called Add, see a zero counter and return while the work is still going on
+// This example is for teaching only: it races, and Wait may even panic with a WaitGroup
+// misuse error
+func Example46Wrong() {
+	fmt.Fprintln(Output, "finished before Wait returned:", waitGroupAddInside(50))
+}
+
+// Example46Correct calls wg.Add before starting each goroutine, so Wait always returns after
+// all of them have called Done
+func Example46Correct() {
+	fmt.Fprintln(Output, "finished before Wait returned:", waitGroupAddBefore(50))
+}
+
+// waitGroupAddInside starts n goroutines that each call wg.Add themselves and returns how
+// many had finished when Wait returned
+func waitGroupAddInside(n int) int64 {
+	var wg sync.WaitGroup
+	var finished atomic.Int64
+	for range n {
+		go func() {
+			join(&wg) // too late: races with wg.Wait below
+			defer wg.Done()
+			finished.Add(1)
+		}()
+	}
+	wg.Wait()
+	return finished.Load()
+}
+
+// join adds the calling goroutine to wg. go vet reports a wg.Add(1) written directly inside a
+// go statement's function literal, so the mistake is hidden behind a call here, the way it
+// usually is in real code
+func join(wg *sync.WaitGroup) {
+	wg.Add(1)
+}
+
+// waitGroupAddBefore starts n goroutines after adding them to the WaitGroup and returns how
+// many had finished when Wait returned
+func waitGroupAddBefore(n int) int64 {
+	var wg sync.WaitGroup
+	var finished atomic.Int64
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			finished.Add(1)
+		}()
+	}
+	wg.Wait()
+	return finished.Load()
+}
+
+// Countdown latch
+// A latch opens once it has been counted down a fixed number of times. Unlike sync.Once it
+// waits for many events, and unlike a WaitGroup its count is fixed when it is created, so
+// there is no Add to misplace as in Example46Wrong
+
+// Latch blocks Wait callers until Done has been called n times. Opening the latch closes a
+// channel, so every Done call is synchronized before every Wait returns
+type Latch struct {
+	mu    sync.Mutex
+	count int
+	open  chan struct{}
+}
+
+// NewLatch returns a latch that opens after n calls to Done. A latch with n <= 0 starts open
+func NewLatch(n int) *Latch {
+	l := &Latch{count: n, open: make(chan struct{})}
+	if n <= 0 {
+		close(l.open)
+	}
+	return l
+}
+
+// Done counts the latch down by one. Calls after the latch has opened have no effect
+func (l *Latch) Done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count <= 0 {
+		return
+	}
+	l.count--
+	if l.count == 0 {
+		close(l.open)
+	}
+}
+
+// Wait blocks until the latch is open
+func (l *Latch) Wait() {
+	<-l.open
+}
+
+// Example54 waits on a latch for three goroutines to report in
+func Example54() {
+	l := NewLatch(3)
+	var reported atomic.Int32
+	for range 3 {
+		go func() {
+			reported.Add(1)
+			l.Done()
+		}()
+	}
+	l.Wait()
+	fmt.Fprintln(Output, "reported:", reported.Load())
+}
+
+// Spinning versus blocking
+// A spinlock keeps the waiting goroutine busy retrying, while sync.Mutex spins only briefly
+// and then parks the goroutine until the lock is released. The benchmarks in
+// primitives_test.go compare the two:
+//   - under low contention the lock is almost always free, both take the fast path of a
+//     single CAS, and they perform about the same
+//   - under high contention the spinlock burns CPU on failed CAS attempts and scheduler
+//     yields that the lock holder could have used, and every release triggers a stampede of
+//     retries. sync.Mutex parks waiters instead and degrades more gracefully; the gap grows
+//     with the number of CPUs and with the length of the critical section
+//
+// The spinlock only pays off when critical sections are tiny and goroutines rarely collide,
+// and even then not by much. sync.Mutex is the right default; it already spins a little when
+// that is likely to help
+
+// Example56 counts with a spinlock and a sync.Mutex under the same load. Both are correct;
+// they only differ in cost, which the benchmarks measure
+func Example56() {
+	fmt.Fprintln(Output, "spinlock:", lockedCount(&spinLock{}, 4, 1000))
+	fmt.Fprintln(Output, "mutex:", lockedCount(&sync.Mutex{}, 4, 1000))
+}
+
+// Concurrent counter maps
+// Two ways to count occurrences of keys from many goroutines: a plain map behind a mutex, and
+// a sync.Map of atomic counters. The mutex map is simpler and is hard to beat when keys are
+// added often. The sync.Map only pays off when the set of keys is stable and most operations
+// are reads or increments of existing counters, which then never contend on a shared lock
+
+// Example60 counts the same stream of words with both maps
+func Example60() {
+	words := []string{"go", "memory", "model", "go", "go", "model"}
+	for _, m := range []counterMap{newMutexCounterMap(), &syncCounterMap{}} {
+		countConcurrently(m, words, 3)
+		fmt.Fprintln(Output, m.Get("go"), m.Get("memory"), m.Get("model"))
+	}
+}
+
+// counterMap is a map of counters safe for concurrent use
+type counterMap interface {
+	Inc(key string)
+	Get(key string) int64
+}
+
+// mutexCounterMap is a counter map guarded by a sync.Mutex
+type mutexCounterMap struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newMutexCounterMap() *mutexCounterMap {
+	return &mutexCounterMap{counts: make(map[string]int64)}
+}
+
+func (m *mutexCounterMap) Inc(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[key]++
+}
+
+func (m *mutexCounterMap) Get(key string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[key]
+}
+
+// syncCounterMap is a counter map backed by a sync.Map of *atomic.Int64
+type syncCounterMap struct {
+	counts sync.Map
+}
+
+func (m *syncCounterMap) Inc(key string) {
+	c, ok := m.counts.Load(key)
+	if !ok {
+		// LoadOrStore settles races between goroutines adding the same key, every one of them
+		// ends up with the same counter
+		c, _ = m.counts.LoadOrStore(key, new(atomic.Int64))
+	}
+	c.(*atomic.Int64).Add(1)
+}
+
+func (m *syncCounterMap) Get(key string) int64 {
+	c, ok := m.counts.Load(key)
+	if !ok {
+		return 0
+	}
+	return c.(*atomic.Int64).Load()
+}
+
+// countConcurrently counts every word in m, splitting the words across goroutines
+func countConcurrently(m counterMap, words []string, goroutines int) {
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(words); i += goroutines {
+				m.Inc(words[i])
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// False sharing
+// Caches move memory between cores in blocks called cache lines, 64 bytes on most current
+// CPUs. A core that writes to a line takes exclusive ownership of the whole line, so two
+// goroutines on different cores writing to different variables that happen to share a line
+// keep stealing it from each other, even though they never touch the same memory. This is
+// called false sharing
+//
+// False sharing is purely a performance problem. The memory model is defined in terms of
+// variables, not cache lines, and counters that are only ever written by their own goroutine
+// do not race no matter how they are laid out. Padding each counter to a cache line of its own
+// changes nothing about what the program computes, only how fast it runs. BenchmarkFalseSharing
+// and BenchmarkPadded in primitives_test.go measure the difference, which only shows up when
+// the goroutines actually run on several cores at once
+//
+// Some CPUs fetch cache lines in pairs, in which case 128 bytes of padding are needed to
+// remove the effect completely
+
+// cacheLineSize is the cache line size assumed by paddedCounter
+const cacheLineSize = 64
+
+// paddedCounter is a counter that takes up a full cache line, so that neighbouring counters in
+// an array never share one
+type paddedCounter struct {
+	n atomic.Int64
+	_ [cacheLineSize - unsafe.Sizeof(atomic.Int64{})]byte
+}
+
+// Example65 counts with one counter per goroutine, first with the counters packed next to each
+// other and then padded to a cache line each. Both give the same totals
+func Example65() {
+	var packed [4]atomic.Int64
+	var padded [4]paddedCounter
+	var packedPtrs, paddedPtrs []*atomic.Int64
+	for i := range packed {
+		packedPtrs = append(packedPtrs, &packed[i])
+		paddedPtrs = append(paddedPtrs, &padded[i].n)
+	}
+	fmt.Fprintln(Output, "packed:", incrementEach(packedPtrs, 1000), "stride", unsafe.Sizeof(packed[0]), "bytes")
+	fmt.Fprintln(Output, "padded:", incrementEach(paddedPtrs, 1000), "stride", unsafe.Sizeof(padded[0]), "bytes")
+}
+
+// incrementEach increments every counter n times from a goroutine of its own and returns the
+// total of all counters afterwards
+func incrementEach(counters []*atomic.Int64, n int) int64 {
+	var wg sync.WaitGroup
+	wg.Add(len(counters))
+	for _, c := range counters {
+		go func(c *atomic.Int64) {
+			defer wg.Done()
+			for range n {
+				c.Add(1)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	var total int64
+	for _, c := range counters {
+		total += c.Load()
+	}
+	return total
+}
+
+// Memoization
+// A cache in front of an expensive function has two jobs under concurrency: storing results
+// safely, and making sure that callers arriving at the same time for a missing key do not all
+// compute it. A sync.Map handles the first, since cached keys are written once and then only
+// read. singleflight.Group handles the second: while a call for a key is in flight, further
+// calls for that key wait for it and share its result instead of starting their own. The
+// first call's return is synchronized before Do returns in every waiting caller
+
+// memo caches the results of compute by key
+type memo[V any] struct {
+	compute func(key string) V
+	values  sync.Map // key -> V
+	calls   singleflight.Group
+}
+
+func newMemo[V any](compute func(key string) V) *memo[V] {
+	return &memo[V]{compute: compute}
+}
+
+// Get returns the cached result for key, computing it first if it is not cached yet
+func (m *memo[V]) Get(key string) V {
+	if v, ok := m.values.Load(key); ok {
+		return v.(V)
+	}
+	v, _, _ := m.calls.Do(key, func() (any, error) {
+		// a caller that missed the cache just after an earlier call finished would otherwise
+		// compute the value again
+		if v, ok := m.values.Load(key); ok {
+			return v, nil
+		}
+		v := m.compute(key)
+		m.values.Store(key, v)
+		return v, nil
+	})
+	return v.(V)
+}
+
+// Example71 has several goroutines ask a memoized slow function for the same key at once
+func Example71() {
+	var computed atomic.Int64
+	m := newMemo(func(key string) string {
+		computed.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return strings.ToUpper(key)
+	})
+
+	results := make([]string, 5)
+	var wg sync.WaitGroup
+	wg.Add(len(results))
+	for i := range results {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = m.Get("hello world")
+		}(i)
+	}
+	wg.Wait()
+	fmt.Fprintln(Output, results)
+	fmt.Fprintln(Output, "computed:", computed.Load(), "cached:", m.Get("hello world"))
+}
+
+// ConcurrentSet is a set of values safe for concurrent use. Every method holds the set's lock
+// for its whole duration, so the operations appear to happen one at a time in some order,
+// and each one sees the effects of all those before it
+type ConcurrentSet[T comparable] struct {
+	mu    sync.RWMutex
+	items map[T]struct{}
+}
+
+// NewConcurrentSet returns an empty set
+func NewConcurrentSet[T comparable]() *ConcurrentSet[T] {
+	return &ConcurrentSet[T]{items: make(map[T]struct{})}
+}
+
+// Add adds v to the set and reports whether it was missing before
+func (s *ConcurrentSet[T]) Add(v T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[v]; ok {
+		return false
+	}
+	s.items[v] = struct{}{}
+	return true
+}
+
+// Remove removes v from the set and reports whether it was present
+func (s *ConcurrentSet[T]) Remove(v T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[v]; !ok {
+		return false
+	}
+	delete(s.items, v)
+	return true
+}
+
+// Contains reports whether v is in the set
+func (s *ConcurrentSet[T]) Contains(v T) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.items[v]
+	return ok
+}
+
+// Len returns the number of values in the set
+func (s *ConcurrentSet[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+// Example83 adds overlapping words to a set from several goroutines
+func Example83() {
+	s := NewConcurrentSet[string]()
+	var wg sync.WaitGroup
+	for _, words := range [][]string{{"hello", "world"}, {"world", "go"}, {"go", "hello"}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, w := range words {
+				s.Add(w)
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Fprintln(Output, "len:", s.Len(), "contains go:", s.Contains("go"), "contains memory:", s.Contains("memory"))
+}
+
+// Channels as locks
+// A channel with a buffer of one can act as a mutex: a send acquires it, since it blocks
+// while the buffer holds a value, and a receive releases it. The channel rules provide the
+// same guarantee as Unlock and Lock: with capacity C = 1, the receive in Unlock is
+// synchronized before the completion of the next send in Lock, so the next holder sees
+// everything written under the lock
+//
+// It works, but it is not what channels are for. Every operation goes through the channel's
+// internal lock and, under contention, parks and wakes goroutines through the channel's wait
+// queues, while sync.Mutex takes a single CAS when the lock is free and spins briefly before
+// parking. BenchmarkChanMutex* and BenchmarkMutex* in primitives_test.go compare the two;
+// expect the channel to be a few times slower. A channel lock does have one trick a
+// sync.Mutex lacks: Lock can be part of a select, so acquiring it can time out or be
+// cancelled as in Example48
+
+// chanMutex is a mutual exclusion lock built from a buffered channel
+type chanMutex chan struct{}
+
+func newChanMutex() chanMutex {
+	return make(chanMutex, 1)
+}
+
+func (m chanMutex) Lock() {
+	m <- struct{}{}
+}
+
+func (m chanMutex) Unlock() {
+	<-m
+}
+
+// Example92 protects a counter shared by four goroutines with a channel lock
+func Example92() {
+	fmt.Fprintln(Output, lockedCount(newChanMutex(), 4, 1000))
+}
+
+// Bounded caches
+// A cache with a size limit keeps its entries in a map for lookups and in a list ordered by
+// recency for eviction. The two have to change together: an entry evicted from the list but
+// still in the map, or the other way round, breaks the cache for good. A single mutex around
+// both keeps every operation atomic. Get needs the exclusive lock too, since it moves the
+// entry it finds to the front of the list, so an RWMutex would not help here
+
+// lruCache is a fixed-capacity cache that evicts the least recently used entry when full
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of *lruEntry[K, V], most recently used first
+	items    map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLRUCache returns an empty cache that holds at most capacity entries. A capacity below 1
+// is treated as 1, since Put always keeps the entry it just added
+func newLRUCache[K comparable, V any](capacity int) *lruCache[K, V] {
+	return &lruCache[K, V]{capacity: max(capacity, 1), order: list.New(), items: make(map[K]*list.Element)}
+}
+
+// Get returns the value for key and reports whether it was cached
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Put caches value under key, evicting the least recently used entry if the cache is full
+func (c *lruCache[K, V]) Put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+// Len returns the number of cached entries
+func (c *lruCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Example93 fills a cache of two entries with three words, using the first word in between
+func Example93() {
+	c := newLRUCache[string, int](2)
+	c.Put("hello", 5)
+	c.Put("world", 5)
+	c.Get("hello") // now more recently used than world
+	c.Put("go", 2) // evicts world
+	for _, k := range []string{"hello", "world", "go"} {
+		v, ok := c.Get(k)
+		fmt.Fprintln(Output, k, v, ok)
+	}
+	fmt.Fprintln(Output, "len:", c.Len())
+}
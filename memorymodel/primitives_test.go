@@ -0,0 +1,772 @@
+package memorymodel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestExample11InOrder(t *testing.T) {
+	got := produceConsume(100, 4)
+	if len(got) != 100 {
+		t.Fatalf("consumer received %d items, want 100", len(got))
+	}
+	for i, v := range got {
+		if v != i+1 {
+			t.Fatalf("item %d = %d, want %d", i, v, i+1)
+		}
+	}
+}
+
+func TestExample18NoTornReads(t *testing.T) {
+	torn, final := hotReload(8, 200, 100*time.Microsecond)
+	if torn != 0 {
+		t.Errorf("readers saw %d torn configs, want 0", torn)
+	}
+	if final.Version != 200 || !consistent(final) {
+		t.Errorf("final config = %+v, want version 200", *final)
+	}
+}
+
+func TestExample20RespectsBudget(t *testing.T) {
+	var weights []int64
+	for i := range 60 {
+		weights = append(weights, int64(i%3+1))
+	}
+	peak, err := runWeighted(context.Background(), 5, weights, func() { time.Sleep(time.Millisecond) })
+	if err != nil {
+		t.Fatalf("runWeighted: %v", err)
+	}
+	if peak > 5 {
+		t.Errorf("peak in-flight weight = %d, want at most 5", peak)
+	}
+}
+
+func TestExample23Spinlock(t *testing.T) {
+	if got := lockedCount(&spinLock{}, 8, 500); got != 4000 {
+		t.Errorf("count = %d, want 4000", got)
+	}
+}
+
+func TestExample26SyncMap(t *testing.T) {
+	m, hits := populateSyncMap(8, 100)
+	if hits > 8*100 {
+		t.Errorf("readers reported %d hits, want at most %d", hits, 8*100)
+	}
+	if n := countEntries(m); n != 100 {
+		t.Errorf("Range observed %d entries, want 100", n)
+	}
+	for k := range 100 {
+		if v, ok := m.Load(k); !ok || v.(int) != k*k {
+			t.Errorf("m[%d] = %v, %v; want %d, true", k, v, ok, k*k)
+		}
+	}
+}
+
+func TestExample27PoolReuse(t *testing.T) {
+	// dirty a buffer so that a Get without Reset would leak its contents into a result
+	dirty := bufferPool.Get().(*bytes.Buffer)
+	dirty.WriteString("stale data ")
+	bufferPool.Put(dirty)
+
+	for round := range 20 {
+		for i, s := range renderAll(100) {
+			if want := fmt.Sprintf("hello %d", i); s != want {
+				t.Fatalf("round %d: render(%d) = %q, want %q", round, i, s, want)
+			}
+		}
+	}
+}
+
+func TestExample30TryLock(t *testing.T) {
+	whileHeld, afterRelease := tryLockContended()
+	if whileHeld {
+		t.Error("TryLock succeeded while the lock was held")
+	}
+	if !afterRelease {
+		t.Error("TryLock failed after the lock was released")
+	}
+}
+
+func TestExample38TreiberStack(t *testing.T) {
+	const goroutines, perGoroutine = 8, 1000
+	var s treiberStack
+	popped := make([][]int, goroutines)
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int) {
+			defer wg.Done()
+			// every push is followed by a pop, so the stack is never empty when popping
+			for i := range perGoroutine {
+				s.Push(g*perGoroutine + i)
+				v, ok := s.Pop()
+				if !ok {
+					t.Error("Pop on a non-empty stack failed")
+					return
+				}
+				popped[g] = append(popped[g], v)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for _, vs := range popped {
+		for _, v := range vs {
+			if seen[v] {
+				t.Fatalf("value %d popped twice", v)
+			}
+			seen[v] = true
+		}
+	}
+	if len(seen) != goroutines*perGoroutine {
+		t.Errorf("popped %d distinct values, want %d", len(seen), goroutines*perGoroutine)
+	}
+	if _, ok := s.Pop(); ok {
+		t.Error("stack should be empty")
+	}
+}
+
+func TestExample40Barrier(t *testing.T) {
+	for range 50 {
+		events := runPhases(10)
+		if len(events) != 20 {
+			t.Fatalf("recorded %d events, want 20", len(events))
+		}
+		if !phasesOrdered(events) {
+			t.Fatalf("a goroutine started phase 2 before all finished phase 1: %v", events)
+		}
+	}
+}
+
+func TestExample42UnboundedQueue(t *testing.T) {
+	const producers, perProducer = 4, 50
+	q := newUnboundedQueue()
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := range producers {
+		go func(p int) {
+			defer wg.Done()
+			for i := range perProducer {
+				q.Put(p*perProducer + i)
+			}
+		}(p)
+	}
+
+	got := make(chan []int)
+	go func() {
+		var items []int
+		for v, ok := q.Get(); ok; v, ok = q.Get() {
+			items = append(items, v)
+		}
+		got <- items
+	}()
+	wg.Wait()
+	q.Close()
+
+	items := <-got
+	slices.Sort(items)
+	want := make([]int, producers*perProducer)
+	for i := range want {
+		want[i] = i
+	}
+	if !slices.Equal(items, want) {
+		t.Errorf("consumer got %d items %v, want %v", len(items), items, want)
+	}
+	if q.Put(1) {
+		t.Error("Put on a closed queue succeeded")
+	}
+}
+
+func TestExample42CloseWakesConsumer(t *testing.T) {
+	q := newUnboundedQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Get()
+		done <- ok
+	}()
+	time.Sleep(10 * time.Millisecond) // let the consumer block in Wait
+	q.Close()
+	if ok := <-done; ok {
+		t.Error("Get on a closed empty queue reported an item")
+	}
+}
+
+func TestExample46Correct(t *testing.T) {
+	for range 100 {
+		if n := waitGroupAddBefore(50); n != 50 {
+			t.Fatalf("Wait returned after %d of 50 goroutines finished", n)
+		}
+	}
+}
+
+func TestExample51RWTryLock(t *testing.T) {
+	whileReading, afterRelease := tryLockWhileReading()
+	if whileReading {
+		t.Error("TryLock succeeded while a read lock was held")
+	}
+	if !afterRelease {
+		t.Error("TryLock failed after the read lock was released")
+	}
+
+	var l sync.RWMutex
+	l.Lock()
+	if tryRLockAndRelease(&l) {
+		t.Error("TryRLock succeeded while the write lock was held")
+	}
+	l.Unlock()
+	if !tryRLockAndRelease(&l) {
+		t.Error("TryRLock failed on a free lock")
+	}
+}
+
+func TestExample54Latch(t *testing.T) {
+	const n = 20
+	l := NewLatch(n)
+	var done atomic.Int32
+	for range n {
+		go func() {
+			time.Sleep(time.Millisecond)
+			done.Add(1)
+			l.Done()
+		}()
+	}
+	l.Wait()
+	if got := done.Load(); got != n {
+		t.Errorf("Wait returned after %d of %d Done calls", got, n)
+	}
+	l.Done() // extra calls are ignored
+	l.Wait()
+}
+
+func TestExample54LatchBlocks(t *testing.T) {
+	l := NewLatch(2)
+	l.Done()
+	waited := make(chan struct{})
+	go func() {
+		l.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before the latch was counted down")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Done()
+	<-waited
+
+	NewLatch(0).Wait() // starts open
+}
+
+func TestExample60CounterMaps(t *testing.T) {
+	var words []string
+	for i := range 5000 {
+		words = append(words, fmt.Sprintf("key%d", i%37))
+	}
+
+	mutexMap, syncMap := newMutexCounterMap(), &syncCounterMap{}
+	countConcurrently(mutexMap, words, 8)
+	countConcurrently(syncMap, words, 8)
+
+	var total int64
+	for k := range 37 {
+		key := fmt.Sprintf("key%d", k)
+		if a, b := mutexMap.Get(key), syncMap.Get(key); a != b {
+			t.Errorf("%s: mutex map counted %d, sync.Map counted %d", key, a, b)
+		}
+		total += syncMap.Get(key)
+	}
+	if total != 5000 {
+		t.Errorf("counted %d words in total, want 5000", total)
+	}
+}
+
+// The counter benchmarks increment a shared counter b.N times in total from GOMAXPROCS
+// goroutines. Run them with `go test -bench Counter` to compare the cost of each primitive
+
+func BenchmarkCounterMutex(b *testing.B) {
+	var mu sync.Mutex
+	var count int
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}
+	})
+	if count != b.N {
+		b.Fatalf("count = %d, want %d", count, b.N)
+	}
+}
+
+func BenchmarkCounterAtomic(b *testing.B) {
+	var count atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			count.Add(1)
+		}
+	})
+	if n := count.Load(); n != int64(b.N) {
+		b.Fatalf("count = %d, want %d", n, b.N)
+	}
+}
+
+func BenchmarkCounterChannel(b *testing.B) {
+	incr := make(chan struct{}, 128)
+	total := make(chan int)
+	go func() { // the single owner of the counter
+		var count int
+		for range incr {
+			count++
+		}
+		total <- count
+	}()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			incr <- struct{}{}
+		}
+	})
+	close(incr)
+	if count := <-total; count != b.N {
+		b.Fatalf("count = %d, want %d", count, b.N)
+	}
+}
+
+// The lock benchmarks take and release a lock b.N times in total from GOMAXPROCS goroutines.
+// Under low contention each goroutine does some work of its own between acquisitions, under
+// high contention it does nothing but take the lock
+
+// localWork simulates work done outside the critical section
+func localWork() int {
+	x := 0
+	for i := range 200 {
+		x += i * i
+	}
+	return x
+}
+
+func benchmarkLock(b *testing.B, l sync.Locker, contended bool) {
+	var count int
+	b.RunParallel(func(pb *testing.PB) {
+		sink := 0
+		for pb.Next() {
+			if !contended {
+				sink += localWork()
+			}
+			l.Lock()
+			count++
+			l.Unlock()
+		}
+		_ = sink
+	})
+	if count != b.N {
+		b.Fatalf("count = %d, want %d", count, b.N)
+	}
+}
+
+func BenchmarkSpinlockLowContention(b *testing.B) {
+	benchmarkLock(b, &spinLock{}, false)
+}
+
+func BenchmarkMutexLowContention(b *testing.B) {
+	benchmarkLock(b, &sync.Mutex{}, false)
+}
+
+func BenchmarkSpinlockHighContention(b *testing.B) {
+	benchmarkLock(b, &spinLock{}, true)
+}
+
+func BenchmarkMutexHighContention(b *testing.B) {
+	benchmarkLock(b, &sync.Mutex{}, true)
+}
+
+// The counter map benchmarks work on a fixed set of keys. The read-heavy workload does one
+// increment for every 15 reads, the write-heavy workload only increments. Neither adds keys
+// after setup; adding keys is where sync.Map is at its slowest
+
+var benchKeys = func() []string {
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	return keys
+}()
+
+func benchmarkCounterMap(b *testing.B, m counterMap, readsPerWrite int) {
+	for _, k := range benchKeys {
+		m.Inc(k)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := benchKeys[i%len(benchKeys)]
+			if i%(readsPerWrite+1) == 0 {
+				m.Inc(k)
+			} else {
+				m.Get(k)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkCounterMapMutexReadHeavy(b *testing.B) {
+	benchmarkCounterMap(b, newMutexCounterMap(), 15)
+}
+
+func BenchmarkCounterMapSyncReadHeavy(b *testing.B) {
+	benchmarkCounterMap(b, &syncCounterMap{}, 15)
+}
+
+func BenchmarkCounterMapMutexWriteHeavy(b *testing.B) {
+	benchmarkCounterMap(b, newMutexCounterMap(), 0)
+}
+
+func BenchmarkCounterMapSyncWriteHeavy(b *testing.B) {
+	benchmarkCounterMap(b, &syncCounterMap{}, 0)
+}
+
+func TestExample65PaddedLayout(t *testing.T) {
+	var padded [2]paddedCounter
+	a := uintptr(unsafe.Pointer(&padded[0].n))
+	b := uintptr(unsafe.Pointer(&padded[1].n))
+	if b-a < cacheLineSize {
+		t.Errorf("padded counters are %d bytes apart, want at least %d", b-a, cacheLineSize)
+	}
+}
+
+func TestExample65Totals(t *testing.T) {
+	var padded [8]paddedCounter
+	var counters []*atomic.Int64
+	for i := range padded {
+		counters = append(counters, &padded[i].n)
+	}
+	if got := incrementEach(counters, 500); got != 8*500 {
+		t.Errorf("total = %d, want %d", got, 8*500)
+	}
+}
+
+// benchmarkCounters has every parallel goroutine increment a counter of its own
+func benchmarkCounters(b *testing.B, counters []*atomic.Int64) {
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		c := counters[int(next.Add(1)-1)%len(counters)]
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+func BenchmarkFalseSharing(b *testing.B) {
+	packed := make([]atomic.Int64, runtime.GOMAXPROCS(0))
+	var counters []*atomic.Int64
+	for i := range packed {
+		counters = append(counters, &packed[i])
+	}
+	benchmarkCounters(b, counters)
+}
+
+func BenchmarkPadded(b *testing.B) {
+	padded := make([]paddedCounter, runtime.GOMAXPROCS(0))
+	var counters []*atomic.Int64
+	for i := range padded {
+		counters = append(counters, &padded[i].n)
+	}
+	benchmarkCounters(b, counters)
+}
+
+func TestExample71ComputesOnce(t *testing.T) {
+	var computed atomic.Int64
+	release := make(chan struct{})
+	m := newMemo(func(key string) int {
+		computed.Add(1)
+		<-release // keep the call in flight until every caller is waiting
+		return len(key)
+	})
+
+	const callers = 100
+	results := make([]int, callers)
+	var started, wg sync.WaitGroup
+	started.Add(callers)
+	wg.Add(callers)
+	for i := range callers {
+		go func(i int) {
+			defer wg.Done()
+			started.Done()
+			results[i] = m.Get("hello world")
+		}(i)
+	}
+	started.Wait()
+	time.Sleep(10 * time.Millisecond) // let the callers reach Get
+	close(release)
+	wg.Wait()
+
+	if n := computed.Load(); n != 1 {
+		t.Errorf("computed %d times, want 1", n)
+	}
+	for i, v := range results {
+		if v != 11 {
+			t.Errorf("caller %d got %d, want 11", i, v)
+		}
+	}
+}
+
+func TestExample71DistinctKeys(t *testing.T) {
+	var computed atomic.Int64
+	m := newMemo(func(key string) string {
+		computed.Add(1)
+		return key + key
+	})
+	var wg sync.WaitGroup
+	for range 10 {
+		for _, key := range []string{"a", "b", "c"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if v := m.Get(key); v != key+key {
+					t.Errorf("Get(%q) = %q, want %q", key, v, key+key)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if n := computed.Load(); n != 3 {
+		t.Errorf("computed %d times, want once per key", n)
+	}
+}
+
+func TestExample77ConsistentLoads(t *testing.T) {
+	torn, final := rcuReload(8, 10000)
+	if torn != 0 {
+		t.Errorf("readers saw %d torn configs, want 0", torn)
+	}
+	if final.Version != 10000 || !consistent(final) {
+		t.Errorf("final config = %+v, want version 10000", *final)
+	}
+}
+
+func TestExample77ConcurrentUpdates(t *testing.T) {
+	var current atomic.Pointer[Config]
+	first := newVersionedConfig(0)
+	current.Store(first)
+
+	const writers, updates = 4, 500
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for range writers {
+		go func() {
+			defer wg.Done()
+			for range updates {
+				rcuUpdate(&current, func(c *Config) {
+					c.Version++
+					c.Name = fmt.Sprintf("v%d", c.Version)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c := current.Load(); c.Version != writers*updates || !consistent(c) {
+		t.Errorf("final config = %+v, want version %d", *c, writers*updates)
+	}
+	if first.Version != 0 || !consistent(first) {
+		t.Errorf("the original config was modified in place: %+v", *first)
+	}
+}
+
+func TestExample83ConcurrentSet(t *testing.T) {
+	s := NewConcurrentSet[int]()
+	if !s.Add(1) || s.Add(1) {
+		t.Error("Add should report true for a new value only")
+	}
+	if !s.Contains(1) || s.Contains(2) || s.Len() != 1 {
+		t.Errorf("set after Add(1): Contains(1)=%v Contains(2)=%v Len=%d", s.Contains(1), s.Contains(2), s.Len())
+	}
+	if !s.Remove(1) || s.Remove(1) || s.Len() != 0 {
+		t.Error("Remove should report true for a present value only")
+	}
+}
+
+func TestExample83ConcurrentSetLinearizable(t *testing.T) {
+	// In any serial execution, the successful Adds and Removes of a key alternate starting
+	// with an Add, so a key ends up in the set exactly when it was added once more than it was
+	// removed. The concurrent history has to be explainable by one of them
+	const goroutines, ops, keys = 8, 2000, 16
+	s := NewConcurrentSet[int]()
+	added := make([][keys]int, goroutines)
+	removed := make([][keys]int, goroutines)
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int) {
+			defer wg.Done()
+			for i := range ops {
+				key := (g*7 + i*3) % keys // every goroutine touches every key
+				if (g+i)%2 == 0 {
+					if s.Add(key) {
+						added[g][key]++
+					}
+				} else if s.Remove(key) {
+					removed[g][key]++
+				}
+				s.Contains(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	members := 0
+	for key := range keys {
+		balance := 0
+		for g := range goroutines {
+			balance += added[g][key] - removed[g][key]
+		}
+		want := 0
+		if s.Contains(key) {
+			want = 1
+			members++
+		}
+		if balance != want {
+			t.Errorf("key %d: %d more successful adds than removes, but Contains = %v", key, balance, want == 1)
+		}
+	}
+	if n := s.Len(); n != members {
+		t.Errorf("Len() = %d, want %d", n, members)
+	}
+}
+
+func TestExample88InconsistentStorePanics(t *testing.T) {
+	r := storeInconsistent()
+	if r == nil || !strings.Contains(fmt.Sprint(r), "inconsistently typed value") {
+		t.Errorf("recovered %v, want an inconsistently typed value panic", r)
+	}
+}
+
+func TestExample88ConsistentStores(t *testing.T) {
+	for range 50 {
+		c := consistentStores(8)
+		if c.Version < 0 || c.Version >= 8 || !consistent(c) {
+			t.Fatalf("loaded config %+v, want one of the stored ones", *c)
+		}
+	}
+}
+
+func TestExample92ChanMutex(t *testing.T) {
+	if got := lockedCount(newChanMutex(), 8, 500); got != 4000 {
+		t.Errorf("count = %d, want 4000", got)
+	}
+}
+
+func BenchmarkChanMutexLowContention(b *testing.B) {
+	benchmarkLock(b, newChanMutex(), false)
+}
+
+func BenchmarkChanMutexHighContention(b *testing.B) {
+	benchmarkLock(b, newChanMutex(), true)
+}
+
+func TestExample93EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[int, string](3)
+	for i := range 3 {
+		c.Put(i, strconv.Itoa(i))
+	}
+	c.Get(0)
+	c.Put(1, "one") // updating also counts as a use
+	c.Put(3, "3")   // evicts 2
+	c.Put(4, "4")   // evicts 0
+
+	for key, want := range map[int]string{1: "one", 3: "3", 4: "4"} {
+		if v, ok := c.Get(key); !ok || v != want {
+			t.Errorf("Get(%d) = %q, %v, want %q, true", key, v, ok, want)
+		}
+	}
+	for _, key := range []int{0, 2} {
+		if v, ok := c.Get(key); ok {
+			t.Errorf("Get(%d) = %q, want it evicted", key, v)
+		}
+	}
+	if n := c.Len(); n != 3 {
+		t.Errorf("Len() = %d, want 3", n)
+	}
+}
+
+func TestExample93CapacityClamped(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		c := newLRUCache[int, string](capacity)
+		c.Put(1, "one")
+		c.Put(2, "two")
+		if n := c.Len(); n != 1 {
+			t.Errorf("capacity %d: Len() = %d, want 1", capacity, n)
+		}
+		if v, ok := c.Get(2); !ok || v != "two" {
+			t.Errorf("capacity %d: Get(2) = %q, %v, want %q, true", capacity, v, ok, "two")
+		}
+	}
+}
+
+func TestExample93Concurrent(t *testing.T) {
+	const capacity, goroutines, keys = 16, 8, 200
+	c := newLRUCache[int, int](capacity)
+
+	stop := make(chan struct{})
+	watcher := make(chan int)
+	go func() { // watch the size while the cache is being filled
+		largest := 0
+		for {
+			select {
+			case <-stop:
+				watcher <- largest
+				return
+			default:
+				largest = max(largest, c.Len())
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := range goroutines {
+		go func(g int) {
+			defer wg.Done()
+			for i := range keys {
+				key := (g*31 + i) % keys
+				c.Put(key, key*10)
+				if v, ok := c.Get((key + 1) % keys); ok && v != ((key+1)%keys)*10 {
+					t.Errorf("Get(%d) = %d, want %d", (key+1)%keys, v, ((key+1)%keys)*10)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(stop)
+
+	if largest := <-watcher; largest > capacity {
+		t.Errorf("cache held %d entries, want at most %d", largest, capacity)
+	}
+	if n := c.Len(); n != capacity {
+		t.Errorf("Len() = %d after %d distinct keys, want %d", n, keys, capacity)
+	}
+	if n := len(c.items); n != c.order.Len() {
+		t.Errorf("map holds %d entries but the eviction list %d", n, c.order.Len())
+	}
+	for key, e := range c.items {
+		if v := e.Value.(*lruEntry[int, int]).value; v != key*10 {
+			t.Errorf("entry %d = %d, want %d", key, v, key*10)
+		}
+	}
+}
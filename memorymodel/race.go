@@ -0,0 +1,31 @@
+//go:build race
+
+package memorymodel
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// raceEnabled reports whether the package was built with the race detector
+const raceEnabled = true
+
+// accessTrack records the last goroutines to access a Guarded value. lastWriter is a plain
+// variable on purpose, so that a write racing with any other access is reported, while
+// lastReader is atomic so that readers never race with each other
+type accessTrack struct {
+	lastWriter int64
+	lastReader atomic.Int64
+}
+
+func (a *accessTrack) read() {
+	_ = a.lastWriter
+	a.lastReader.Store(goroutineID())
+	runtime.Gosched() // widen the window for another goroutine to interleave
+}
+
+func (a *accessTrack) write() {
+	_ = a.lastReader.Load()
+	a.lastWriter = goroutineID()
+	runtime.Gosched()
+}
@@ -0,0 +1,56 @@
+package memorymodel
+
+import (
+	"sync"
+	"time"
+)
+
+// Recorder records named events from any number of goroutines so that their order can be
+// inspected afterwards. The order of the recorded events is the order in which the events
+// acquired the recorder's mutex, so an event recorded before a synchronizing operation is
+// always recorded before any event that happens after it
+// A nil *Recorder is valid and discards all events
+type Recorder struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Entry is a single recorded event
+type Entry struct {
+	Name string
+	At   time.Time
+}
+
+// Event records that the event name happened now
+func (r *Recorder) Event(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, Entry{Name: name, At: time.Now()})
+}
+
+// Events returns the names of the recorded events in the order they were recorded
+func (r *Recorder) Events() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.entries))
+	for i, e := range r.entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+// Entries returns the recorded events along with the time each one was recorded
+func (r *Recorder) Entries() []Entry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
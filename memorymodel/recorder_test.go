@@ -0,0 +1,48 @@
+package memorymodel
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestRecorderConcurrentEvents(t *testing.T) {
+	var rec Recorder
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for range 10 {
+		go func() {
+			defer wg.Done()
+			rec.Event("tick")
+		}()
+	}
+	wg.Wait()
+
+	if n := len(rec.Events()); n != 10 {
+		t.Errorf("recorded %d events, want 10", n)
+	}
+	entries := rec.Entries()
+	for i := 1; i < len(entries); i++ {
+		if entries[i].At.Before(entries[i-1].At) {
+			t.Errorf("entry %d recorded at %v, before entry %d at %v", i, entries[i].At, i-1, entries[i-1].At)
+		}
+	}
+}
+
+func TestRecorderNil(t *testing.T) {
+	var rec *Recorder
+	rec.Event("ignored")
+	if events := rec.Events(); events != nil {
+		t.Errorf("nil recorder returned %v, want nil", events)
+	}
+}
+
+func TestExample7WriteBeforePrint(t *testing.T) {
+	var rec Recorder
+	captureOutput(t, func() { example7(&rec) })
+
+	want := []string{"write", "print"}
+	if got := rec.Events(); !slices.Equal(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package memorymodel
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Examples whose outcome depends on scheduling can opt in to seeded runs by calling
+// schedPoint at the points where a different interleaving would change the outcome. Outside
+// of RunWithSeed schedPoint does nothing
+
+// seedMu serializes RunWithSeed calls, since they change process-wide scheduler settings
+var seedMu sync.Mutex
+
+// activeSchedule is the schedule of the RunWithSeed call in progress, if any
+var activeSchedule atomic.Pointer[schedule]
+
+// schedule decides how often each schedPoint yields, from a seeded random sequence
+type schedule struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newSchedule(seed int64) *schedule {
+	return &schedule{rng: rand.New(rand.NewPCG(uint64(seed), 0))}
+}
+
+func (s *schedule) yields() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.IntN(3)
+}
+
+// schedPoint yields the processor a seed-determined number of times during RunWithSeed
+func schedPoint() {
+	if s := activeSchedule.Load(); s != nil {
+		yield(s.yields())
+	}
+}
+
+// RunWithSeed runs f with its schedPoint calls driven by seed and returns its result
+// Go offers no control over its scheduler, so this cannot make a run fully deterministic.
+// It gets close by running with GOMAXPROCS set to 1, so that goroutines only switch at
+// blocking operations, preemption, or the yields at schedPoint calls, which are drawn from a
+// random sequence seeded with seed. Given the same seed an opted-in example usually makes the
+// same choices and produces the same outcome. It is not always the same: the runtime
+// periodically gives goroutines waiting in its global run queue, where Gosched puts them,
+// priority over the local one, which overrides the seeded choice about once in every 61
+// scheduling rounds
+// GOMAXPROCS is a process-wide setting. RunWithSeed calls are serialized with each other, but
+// anything else running at the same time, such as tests marked with t.Parallel, is confined
+// to a single processor as well while f runs. Do not call it from parallel tests
+func RunWithSeed(seed int64, f func() string) string {
+	seedMu.Lock()
+	defer seedMu.Unlock()
+
+	procs := runtime.GOMAXPROCS(1)
+	defer runtime.GOMAXPROCS(procs)
+
+	activeSchedule.Store(newSchedule(seed))
+	defer activeSchedule.Store(nil)
+	return f()
+}
@@ -0,0 +1,65 @@
+package memorymodel
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScheduleReproducible(t *testing.T) {
+	draws := func(seed int64) []int {
+		s := newSchedule(seed)
+		d := make([]int, 100)
+		for i := range d {
+			d[i] = s.yields()
+		}
+		return d
+	}
+	if a, b := draws(7), draws(7); !slices.Equal(a, b) {
+		t.Errorf("seed 7 drew %v and then %v, want the same yields", a, b)
+	}
+	if a, b := draws(7), draws(8); slices.Equal(a, b) {
+		t.Errorf("seeds 7 and 8 both drew %v, want different yields", a)
+	}
+}
+
+func TestRunWithSeedReproducible(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Example2Value contains a deliberate data race")
+	}
+	// outcome returns the outcome of the large majority of runs with seed. The runtime's run
+	// queue fairness check overrides the seeded schedule in about one run in 60, so a single
+	// run is not guaranteed to match
+	const runs = 50
+	outcome := func(seed int64) string {
+		results := make(map[string]int)
+		for range runs {
+			results[RunWithSeed(seed, Example2Value)]++
+		}
+		for r, n := range results {
+			if n >= runs*9/10 {
+				return r
+			}
+		}
+		t.Fatalf("seed %d: outcomes %v, want one outcome in at least 90%% of runs", seed, results)
+		return ""
+	}
+
+	seen := make(map[string]bool)
+	for seed := range int64(20) {
+		first := outcome(seed)
+		if again := outcome(seed); again != first {
+			t.Errorf("seed %d produced %q and then %q, want the same outcome", seed, first, again)
+		}
+		seen[first] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("every seed produced %v, want the seed to change the outcome", seen)
+	}
+}
+
+func TestRunWithSeedRestoresScheduler(t *testing.T) {
+	RunWithSeed(1, func() string { return "" })
+	if activeSchedule.Load() != nil {
+		t.Error("schedule still active after RunWithSeed returned")
+	}
+}
@@ -0,0 +1,11 @@
+package memorymodel
+
+// Stress runs f n times and tallies how often each distinct result was returned. It makes
+// the outcome distribution of a nondeterministic example observable
+func Stress(n int, f func() string) map[string]int {
+	results := make(map[string]int)
+	for range n {
+		results[f()]++
+	}
+	return results
+}
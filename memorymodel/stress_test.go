@@ -0,0 +1,40 @@
+package memorymodel
+
+import "testing"
+
+func TestStressExample2(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Example2Value contains a deliberate data race")
+	}
+	results := Stress(1000, Example2Value)
+
+	total := 0
+	for outcome, count := range results {
+		if outcome != "empty" && outcome != "hello world" {
+			t.Errorf("unexpected outcome %q", outcome)
+		}
+		total += count
+	}
+	if total != 1000 {
+		t.Errorf("tallied %d runs, want 1000", total)
+	}
+}
+
+func TestStressExample67Gosched(t *testing.T) {
+	if raceEnabled {
+		t.Skip("goschedRead contains a deliberate data race")
+	}
+	// yielding makes "hello world" likely but does not rule out "empty"
+	for outcome := range Stress(1000, goschedRead) {
+		if outcome != "empty" && outcome != "hello world" {
+			t.Errorf("unexpected outcome %q", outcome)
+		}
+	}
+}
+
+func TestStressExample67Atomic(t *testing.T) {
+	results := Stress(1000, goschedAtomicRead)
+	if n := results["hello world"]; n != 1000 || len(results) != 1 {
+		t.Errorf("outcomes %v, want hello world in all 1000 runs", results)
+	}
+}
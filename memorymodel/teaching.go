@@ -0,0 +1,36 @@
+//go:build teaching
+
+package memorymodel
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Examples in this file race on purpose and are only built with `-tags teaching`, so that
+// the default build and `go test -race ./...` never contain them
+
+// Several goroutines append to one shared slice. Appends are lost or overwrite each other, so
+// fewer than 5 values, or the wrong ones, may be printed
+// This example is for teaching only: it races and is expected to fail under `go test -race`
+func Example76Racy() {
+	got := racyAppend(5, func(i int) int { return i * i })
+	slices.Sort(got)
+	fmt.Fprintln(Output, got)
+}
+
+// racyAppend is collectResults with every goroutine appending to a shared slice
+func racyAppend(n int, f func(int) int) []int {
+	var collected []int
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		go func(i int) {
+			defer wg.Done()
+			collected = append(collected, f(i)) // data race
+		}(i)
+	}
+	wg.Wait()
+	return collected
+}
@@ -0,0 +1,114 @@
+package memorymodel
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// VectorClock tracks, for each goroutine, how many of its events are known to have happened
+// before the current point. A goroutine increments its own entry for every event. A
+// synchronizing operation, such as a receive, merges the clock carried by the matching send,
+// so the receiver's clock covers everything that happened before the send
+// Comparing two clocks then tells whether one event happens before the other or whether the
+// two are concurrent, which is exactly the relation the memory model is written in terms of
+type VectorClock map[int]uint64
+
+// Increment records a new event on goroutine id
+func (vc VectorClock) Increment(id int) {
+	vc[id]++
+}
+
+// Merge raises every entry of vc to at least the matching entry of other
+func (vc VectorClock) Merge(other VectorClock) {
+	for id, n := range other {
+		vc[id] = max(vc[id], n)
+	}
+}
+
+// Copy returns an independent copy of vc, e.g. to send alongside a message
+func (vc VectorClock) Copy() VectorClock {
+	c := make(VectorClock, len(vc))
+	c.Merge(vc)
+	return c
+}
+
+// HappensBefore reports whether the event with clock vc happens before the event with clock
+// other: no entry of vc is larger than the matching entry of other, and the clocks differ
+func (vc VectorClock) HappensBefore(other VectorClock) bool {
+	strictly := false
+	for id, n := range vc {
+		switch m := other[id]; {
+		case n > m:
+			return false
+		case n < m:
+			strictly = true
+		}
+	}
+	for id, m := range other {
+		if _, ok := vc[id]; !ok && m > 0 {
+			strictly = true
+		}
+	}
+	return strictly
+}
+
+// Concurrent reports whether neither event happens before the other
+func (vc VectorClock) Concurrent(other VectorClock) bool {
+	return !vc.HappensBefore(other) && !other.HappensBefore(vc)
+}
+
+// String formats vc as {g0:1 g1:2}, ordered by goroutine id
+func (vc VectorClock) String() string {
+	ids := make([]int, 0, len(vc))
+	for id := range vc {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("g%d:%d", id, vc[id])
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// stampedMessage is a value sent on a channel together with the sender's clock
+type stampedMessage struct {
+	value int
+	clock VectorClock
+}
+
+// handoffClocks traces Example3 with vector clocks. Goroutine 1 writes and then sends,
+// goroutine 0 receives and then reads, and each goroutine also has an event that is not
+// ordered with the other goroutine. It returns the clock of each event by name
+func handoffClocks() map[string]VectorClock {
+	clocks := make(map[string]VectorClock)
+	c := make(chan stampedMessage, 1)
+	sentClocks := make(chan map[string]VectorClock)
+
+	go func() { // goroutine 1
+		vc := VectorClock{}
+		events := make(map[string]VectorClock)
+		vc.Increment(1)
+		events["write"] = vc.Copy()
+		vc.Increment(1)
+		c <- stampedMessage{value: 0, clock: vc.Copy()} // the send carries the clock
+		vc.Increment(1)
+		events["after send"] = vc.Copy()
+		sentClocks <- events
+	}()
+
+	vc := VectorClock{} // goroutine 0
+	vc.Increment(0)
+	clocks["before receive"] = vc.Copy()
+	msg := <-c
+	vc.Merge(msg.clock) // the receive learns everything the sender knew
+	vc.Increment(0)
+	clocks["read"] = vc.Copy()
+
+	for name, clock := range <-sentClocks {
+		clocks[name] = clock
+	}
+	return clocks
+}
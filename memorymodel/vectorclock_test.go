@@ -0,0 +1,49 @@
+package memorymodel
+
+import "testing"
+
+func TestVectorClockOrdering(t *testing.T) {
+	a := VectorClock{0: 1}
+	b := a.Copy()
+	b.Increment(1)
+
+	if !a.HappensBefore(b) {
+		t.Errorf("%v should happen before %v", a, b)
+	}
+	if b.HappensBefore(a) {
+		t.Errorf("%v should not happen before %v", b, a)
+	}
+	if a.HappensBefore(a) {
+		t.Errorf("%v should not happen before itself", a)
+	}
+
+	c := VectorClock{0: 2}
+	if !b.Concurrent(c) {
+		t.Errorf("%v and %v should be concurrent", b, c)
+	}
+
+	c.Merge(b)
+	if !b.HappensBefore(c) {
+		t.Errorf("after merging, %v should happen before %v", b, c)
+	}
+	if got, want := c.String(), "{g0:2 g1:1}"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestVectorClockChannelHandoff(t *testing.T) {
+	clocks := handoffClocks()
+
+	// the write is sequenced before the send, which is synchronized before the receive
+	if !clocks["write"].HappensBefore(clocks["read"]) {
+		t.Errorf("write %v should happen before read %v", clocks["write"], clocks["read"])
+	}
+	// nothing orders the sender's later events with the receiver's, or the receiver's
+	// earlier events with the sender's
+	if !clocks["after send"].Concurrent(clocks["read"]) {
+		t.Errorf("after send %v and read %v should be concurrent", clocks["after send"], clocks["read"])
+	}
+	if !clocks["before receive"].Concurrent(clocks["write"]) {
+		t.Errorf("before receive %v and write %v should be concurrent", clocks["before receive"], clocks["write"])
+	}
+}